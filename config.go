@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/mqtt"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/Garionion/playout-controller/studio"
+	"github.com/Garionion/playout-controller/webhook"
+	"github.com/ilyakaznacheev/cleanenv"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const configPollInterval = 5 * time.Second
+
+type Configuration struct {
+	Address              string                   `yaml:"Address" env:"ADDRESS" env-default:":8080"`
+	FahrplanURL          string                   `yaml:"FahrplanUrl" env:"FAHRPLAN_URL"`
+	Fahrplanrefresh      time.Duration            `yaml:"Fahrplanrefresh" env:"FAHRPLAN_REFRESH"`
+	AutoSchedule         bool                     `yaml:"AutoSchedule" env:"AUTOSCHEDULE"`
+	UpcomingInterval     time.Duration            `yaml:"UpcomingInterval" env:"UPCOMINGINTERVAL"`
+	PrePadding           time.Duration            `yaml:"PrePadding"`
+	MaxPostPadding       time.Duration            `yaml:"MaxPostPadding"`
+	RoomPadding          map[string]store.Padding `yaml:"RoomPadding"`
+	TrackPadding         map[string]store.Padding `yaml:"TrackPadding"`
+	TypePadding          map[string]store.Padding `yaml:"TypePadding"`
+	BulkParallelism      int                      `yaml:"BulkParallelism" env-default:"4"`
+	ReconcileInterval    time.Duration            `yaml:"ReconcileInterval" env-default:"1m"`
+	IngestServer         IngestServer             `yaml:"IngestServer"`
+	PlayoutServers       map[string]string        `yaml:"PlayoutServers"`
+	StudioIngestURLFile  string                   `yaml:"StudioIngestURLFile"`
+	TalkIDtoStudioFile   string                   `yaml:"TalkIDtoStudioFile"`
+	StudioAssignmentFile string                   `yaml:"StudioAssignmentFile"`
+	MappingRefresh       time.Duration            `yaml:"MappingRefresh" env-default:"5s"`
+	ShutdownTimeout      time.Duration            `yaml:"ShutdownTimeout" env-default:"10s"`
+	StateFile            string                   `yaml:"StateFile" env-default:"state.json"`
+	HistoryFile          string                   `yaml:"HistoryFile" env-default:"history.db"`
+	Filter               fahrplan.FilterConfig    `yaml:"Filter"`
+	RoomAliases          []fahrplan.RoomAlias     `yaml:"RoomAliases"`
+	UnknownRoomPolicy    RoomPolicy               `yaml:"UnknownRoomPolicy"`
+	RoomPolicies         map[string]RoomPolicy    `yaml:"RoomPolicies"`
+	Webhooks             []webhook.Config         `yaml:"Webhooks"`
+	MQTT                 mqtt.Config              `yaml:"MQTT"`
+
+	rooms    *fahrplan.RoomMapper
+	filter   *fahrplan.Filter
+	webhooks []*webhook.Hook
+}
+
+type IngestServer struct {
+	Nginx   []string `yaml:"nginx,omitempty"`
+	Icecast []string `yaml:"icecast,omitempty"`
+}
+
+func (cfg *Configuration) mappingFiles() studio.Files {
+	return studio.Files{
+		Talks:       cfg.TalkIDtoStudioFile,
+		Studios:     cfg.StudioIngestURLFile,
+		Assignments: cfg.StudioAssignmentFile,
+	}
+}
+
+// validate checks cfg and compiles its derived fields. All problems are reported at once.
+func (cfg *Configuration) validate() error {
+	var problems []string
+	check := func(ok bool, format string, a ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, a...))
+		}
+	}
+	positive := func(name string, d time.Duration) {
+		check(d > 0, "%s has to be positive, got %v", name, d)
+	}
+
+	check(cfg.Address != "", "Address is required")
+	if u, err := url.Parse(cfg.FahrplanURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("FahrplanUrl %q is not a valid URL", cfg.FahrplanURL))
+	}
+	positive("Fahrplanrefresh", cfg.Fahrplanrefresh)
+	positive("UpcomingInterval", cfg.UpcomingInterval)
+	check(cfg.UpcomingInterval <= 0 || cfg.UpcomingInterval/4 > 0, "UpcomingInterval %v is too small", cfg.UpcomingInterval)
+	positive("MappingRefresh", cfg.MappingRefresh)
+	check(cfg.ShutdownTimeout >= 0, "ShutdownTimeout must not be negative")
+	check(cfg.PrePadding >= 0, "PrePadding must not be negative")
+	check(cfg.MaxPostPadding >= 0, "MaxPostPadding must not be negative")
+	problems = append(problems, validatePaddings(cfg)...)
+	positive("ReconcileInterval", cfg.ReconcileInterval)
+	check(cfg.BulkParallelism > 0, "BulkParallelism has to be positive, got %d", cfg.BulkParallelism)
+	check(cfg.TalkIDtoStudioFile != "" || cfg.StudioAssignmentFile != "", "TalkIDtoStudioFile or StudioAssignmentFile is required")
+	check(cfg.StudioIngestURLFile != "", "StudioIngestURLFile is required")
+	for room, address := range cfg.PlayoutServers {
+		check(address != "", "PlayoutServers %s has no address", room)
+	}
+
+	if err := validateRoomPolicies(cfg); err != nil {
+		problems = append(problems, err.Error())
+	}
+	var err error
+	if cfg.rooms, err = fahrplan.NewRoomMapper(cfg.RoomAliases); err != nil {
+		problems = append(problems, "RoomAliases: "+err.Error())
+	}
+	if cfg.filter, err = fahrplan.NewFilter(cfg.Filter); err != nil {
+		problems = append(problems, "Filter: "+err.Error())
+	}
+	if cfg.webhooks, err = webhook.Compile(cfg.Webhooks); err != nil {
+		problems = append(problems, "Webhooks: "+err.Error())
+	}
+	if err := cfg.MQTT.Validate(); err != nil {
+		problems = append(problems, "MQTT: "+err.Error())
+	}
+
+	if len(problems) > 0 {
+		return errors.New("\n\t" + strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+func loadConfig(file string) (*Configuration, error) {
+	cfg := new(Configuration)
+	if err := cleanenv.ReadConfig(file, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// redacted returns a copy of cfg without credentials, suitable for the API.
+func (cfg *Configuration) redacted() Configuration {
+	r := *cfg
+	r.FahrplanURL = redactURL(cfg.FahrplanURL)
+	r.PlayoutServers = make(map[string]string, len(cfg.PlayoutServers))
+	for room, address := range cfg.PlayoutServers {
+		r.PlayoutServers[room] = redactURL(address)
+	}
+	r.IngestServer.Nginx = make([]string, 0, len(cfg.IngestServer.Nginx))
+	for _, u := range cfg.IngestServer.Nginx {
+		r.IngestServer.Nginx = append(r.IngestServer.Nginx, redactURL(u))
+	}
+	r.IngestServer.Icecast = make([]string, 0, len(cfg.IngestServer.Icecast))
+	for _, u := range cfg.IngestServer.Icecast {
+		r.IngestServer.Icecast = append(r.IngestServer.Icecast, redactURL(u))
+	}
+	r.MQTT.Broker = redactURL(cfg.MQTT.Broker)
+	if cfg.MQTT.Password != "" {
+		r.MQTT.Password = "xxxxx"
+	}
+	r.Webhooks = make([]webhook.Config, 0, len(cfg.Webhooks))
+	for _, w := range cfg.Webhooks {
+		r.Webhooks = append(r.Webhooks, w.Redacted())
+	}
+	return r
+}
+
+// configHolder hands out the active configuration. A Configuration is never
+// modified once it is active, a reload replaces it as a whole.
+type configHolder struct {
+	v atomic.Value
+}
+
+func newConfigHolder(cfg *Configuration) *configHolder {
+	h := &configHolder{}
+	h.v.Store(cfg)
+	return h
+}
+
+func (h *configHolder) Get() *Configuration {
+	return h.v.Load().(*Configuration)
+}
+
+// restartOnly lists the settings which are only applied on startup.
+var restartOnly = []string{"Address", "IngestServer", "StudioIngestURLFile", "TalkIDtoStudioFile", "StudioAssignmentFile", "MappingRefresh", "ShutdownTimeout", "StateFile", "HistoryFile", "MQTT"}
+
+// reloadConfig activates the configuration in file. Settings which need a
+// restart keep their old value, an invalid configuration is rejected as a whole.
+func reloadConfig(file string, config *configHolder, s *store.Store) error {
+	next, err := loadConfig(file)
+	if err != nil {
+		return err
+	}
+	current := config.Get()
+	cur := reflect.ValueOf(current).Elem()
+	nxt := reflect.ValueOf(next).Elem()
+	for _, name := range restartOnly {
+		if !reflect.DeepEqual(cur.FieldByName(name).Interface(), nxt.FieldByName(name).Interface()) {
+			log.Printf("Config: %s changed, restart to apply it", name)
+			nxt.FieldByName(name).Set(cur.FieldByName(name))
+		}
+	}
+	if !reflect.DeepEqual(current.PlayoutServers, next.PlayoutServers) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.SetPlayoutServers(ctx, next.PlayoutServers); err != nil {
+			return fmt.Errorf("failed to connect to playout servers: %w", err)
+		}
+	}
+	config.v.Store(next)
+	pruneUnresolvedRooms(next, s)
+	return nil
+}
+
+func configModTime(file string) time.Time {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// watchConfig reloads file whenever it changes or reload receives a value.
+func watchConfig(ctx context.Context, file string, config *configHolder, s *store.Store, reload <-chan os.Signal) chan struct{} {
+	ticker := time.NewTicker(configPollInterval)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		modTime := configModTime(file)
+		for {
+			select {
+			case <-ticker.C:
+				if m := configModTime(file); !m.Equal(modTime) {
+					modTime = m
+				} else {
+					continue
+				}
+			case <-reload:
+				modTime = configModTime(file)
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			}
+			if err := reloadConfig(file, config, s); err != nil {
+				log.Printf("Config: keeping the active configuration, reload failed: %v", err)
+				continue
+			}
+			log.Println("Config: reloaded")
+		}
+	}()
+	return done
+}
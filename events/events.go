@@ -0,0 +1,146 @@
+package events
+
+import (
+	"fmt"
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/metrics"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	// FahrplanUpdated carries all jobs of the current Fahrplan in Jobs.
+	FahrplanUpdated Type = "fahrplanUpdated"
+	// UpcomingChanged carries the jobs starting within the upcoming interval in Jobs.
+	UpcomingChanged Type = "upcomingChanged"
+	// JobScheduled carries the submitted Job and the Scheduled answer of the playout server.
+	JobScheduled Type = "jobScheduled"
+	// JobFailed carries the Job which could not be submitted and the Error. Failed retries
+	// of a job which already failed are not published again.
+	JobFailed Type = "jobFailed"
+	// VersionChanged carries the new Fahrplan Version and, if the history is enabled, the Changes.
+	VersionChanged Type = "versionChanged"
+	// RoomUnresolved carries the Room which has no playout server and the first affected Job.
+	RoomUnresolved Type = "roomUnresolved"
+	// JobStateChanged carries the Job, its new State and its Previous state.
+	JobStateChanged Type = "jobStateChanged"
+)
+
+// Types lists all known event types.
+var Types = []Type{FahrplanUpdated, UpcomingChanged, JobScheduled, JobFailed, VersionChanged, RoomUnresolved, JobStateChanged}
+
+// ParseType returns the event type called name.
+func ParseType(name string) (Type, error) {
+	for _, t := range Types {
+		if string(t) == name {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event type %q", name)
+}
+
+// Event is published on the Bus. Which fields are set depends on Type.
+type Event struct {
+	Type      Type                           `json:"type"`
+	Time      time.Time                      `json:"time"`
+	Jobs      map[string]fahrplan.PlayoutJob `json:"jobs,omitempty"`
+	Job       *fahrplan.PlayoutJob           `json:"job,omitempty"`
+	Scheduled *api.ScheduledJob              `json:"scheduled,omitempty"`
+	Error     string                         `json:"error,omitempty"`
+	Version   string                         `json:"version,omitempty"`
+	Changes   []fahrplan.TalkChange          `json:"changes,omitempty"`
+	Room      string                         `json:"room,omitempty"`
+	State     string                         `json:"state,omitempty"`
+	Previous  string                         `json:"previous,omitempty"`
+}
+
+// Bus delivers events to all interested subscribers. Publishing never blocks:
+// if a subscriber is full, its oldest pending event is dropped.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the events of the requested types, or all events if no type was given.
+type Subscription struct {
+	name  string
+	types map[Type]bool
+	ch    chan Event
+	bus   *Bus
+	once  sync.Once
+}
+
+// Subscribe registers a subscriber which can hold up to buffer pending events.
+// name identifies the subscriber in the metrics.
+func (b *Bus) Subscribe(name string, buffer int, types ...Type) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	s := &Subscription{name: name, ch: make(chan Event, buffer), bus: b}
+	if len(types) > 0 {
+		s.types = map[Type]bool{}
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Publish sends e to all subscribers of its type. A zero Time is set to now.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	metrics.PublishedEvents.Add(string(e.Type), 1)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if s.types != nil && !s.types[e.Type] {
+			continue
+		}
+		s.deliver(e)
+	}
+}
+
+func (s *Subscription) deliver(e Event) {
+	select {
+	case s.ch <- e:
+		return
+	default:
+	}
+	select {
+	case <-s.ch:
+	default:
+	}
+	metrics.DroppedEvents.Add(s.name, 1)
+	select {
+	case s.ch <- e:
+	default:
+		metrics.DroppedEvents.Add(s.name, 1)
+	}
+}
+
+// Events returns the channel the events are delivered on. It is closed by Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Close unsubscribes from the bus.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.ch)
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/Garionion/playout-controller/events"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"time"
+)
+
+const keepaliveInterval = 15 * time.Second
+
+// eventTypes parses a comma separated list of event types, an empty list selects all.
+func eventTypes(list string) ([]events.Type, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var types []events.Type
+	for _, name := range strings.Split(list, ",") {
+		t, err := events.ParseType(name)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// writeSSE writes v in the server-sent events format and flushes it, an error means the client is gone.
+func writeSSE(w *bufio.Writer, id string, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return w.Flush()
+}
+
+// keepalive writes a comment, so dead connections are noticed while nothing happens.
+func keepalive(w *bufio.Writer) error {
+	fmt.Fprint(w, ": keepalive\n\n")
+	return w.Flush()
+}
+
+func setSSEHeaders(c *fiber.Ctx) {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+}
+
+func registerEventRoutes(ctx context.Context, api fiber.Router, bus *events.Bus) {
+	api.Get("/events", func(c *fiber.Ctx) error {
+		types, err := eventTypes(c.Query("types"))
+		if err != nil {
+			return err
+		}
+		sub := bus.Subscribe("sse", 64, types...)
+		setSSEHeaders(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer sub.Close()
+			ticker := time.NewTicker(keepaliveInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case e := <-sub.Events():
+					if writeSSE(w, "", string(e.Type), e) != nil {
+						return
+					}
+				case <-ticker.C:
+					if keepalive(w) != nil {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+		return nil
+	})
+}
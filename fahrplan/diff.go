@@ -0,0 +1,104 @@
+package fahrplan
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeMoved    = "moved"
+	ChangeRoom     = "room"
+	ChangeDuration = "duration"
+)
+
+// TalkVersion is the state of a talk in one Fahrplan version.
+type TalkVersion struct {
+	GUID     string    `json:"guid"`
+	ID       int       `json:"id"`
+	Title    string    `json:"title"`
+	Room     string    `json:"room"`
+	Start    time.Time `json:"start"`
+	Duration string    `json:"duration"`
+}
+
+// TalkChange describes how a talk differs between two Fahrplan versions.
+// Before is nil for added, After for removed talks.
+type TalkChange struct {
+	GUID    string       `json:"guid"`
+	Changes []string     `json:"changes"`
+	Before  *TalkVersion `json:"before,omitempty"`
+	After   *TalkVersion `json:"after,omitempty"`
+}
+
+// Talks returns all talks of schedule by GUID, with their room resolved.
+func Talks(schedule *Fahrplan, rooms *RoomMapper) map[string]TalkVersion {
+	talks := map[string]TalkVersion{}
+	for _, day := range schedule.Schedule.Conference.Days {
+		for fahrplanRoom, r := range day.Rooms {
+			for _, talk := range r {
+				guid := talkGUID(talk)
+				if _, ok := talks[guid]; ok {
+					continue
+				}
+				talks[guid] = TalkVersion{
+					GUID:     guid,
+					ID:       talk.ID,
+					Title:    talk.Title,
+					Room:     rooms.Resolve(fahrplanRoom),
+					Start:    talk.Date,
+					Duration: talk.Duration,
+				}
+			}
+		}
+	}
+	return talks
+}
+
+// Diff lists the talks which were added, removed or changed from before to after, ordered by start.
+func Diff(before map[string]TalkVersion, after map[string]TalkVersion) []TalkChange {
+	changes := []TalkChange{}
+	for guid, a := range after {
+		a := a
+		b, ok := before[guid]
+		if !ok {
+			changes = append(changes, TalkChange{GUID: guid, Changes: []string{ChangeAdded}, After: &a})
+			continue
+		}
+		var kinds []string
+		if !b.Start.Equal(a.Start) {
+			kinds = append(kinds, ChangeMoved)
+		}
+		if b.Room != a.Room {
+			kinds = append(kinds, ChangeRoom)
+		}
+		if b.Duration != a.Duration {
+			kinds = append(kinds, ChangeDuration)
+		}
+		if len(kinds) > 0 {
+			changes = append(changes, TalkChange{GUID: guid, Changes: kinds, Before: &b, After: &a})
+		}
+	}
+	for guid, b := range before {
+		b := b
+		if _, ok := after[guid]; !ok {
+			changes = append(changes, TalkChange{GUID: guid, Changes: []string{ChangeRemoved}, Before: &b})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		si, sj := changes[i].start(), changes[j].start()
+		if !si.Equal(sj) {
+			return si.Before(sj)
+		}
+		return changes[i].GUID < changes[j].GUID
+	})
+	return changes
+}
+
+func (c TalkChange) start() time.Time {
+	if c.After != nil {
+		return c.After.Start
+	}
+	return c.Before.Start
+}
@@ -0,0 +1,43 @@
+package fahrplan
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2020, 12, 27, hour, 0, 0, 0, time.UTC) }
+	talk := TalkVersion{GUID: "a", ID: 1, Title: "Opening", Room: "Saal 1", Start: at(10), Duration: "00:30"}
+	other := TalkVersion{GUID: "b", ID: 2, Title: "Closing", Room: "Saal 2", Start: at(9), Duration: "01:00"}
+	moved, rescheduled := talk, talk
+	moved.Start, moved.Room, moved.Duration = at(11), "Saal 2", "00:45"
+	rescheduled.Title = "Opening Ceremony"
+	tests := []struct {
+		name   string
+		before map[string]TalkVersion
+		after  map[string]TalkVersion
+		want   []TalkChange
+	}{
+		{"first version", nil, map[string]TalkVersion{"a": talk},
+			[]TalkChange{{GUID: "a", Changes: []string{ChangeAdded}, After: &talk}}},
+		{"unchanged", map[string]TalkVersion{"a": talk}, map[string]TalkVersion{"a": talk}, []TalkChange{}},
+		{"title only", map[string]TalkVersion{"a": talk}, map[string]TalkVersion{"a": rescheduled}, []TalkChange{}},
+		{"removed", map[string]TalkVersion{"a": talk}, map[string]TalkVersion{},
+			[]TalkChange{{GUID: "a", Changes: []string{ChangeRemoved}, Before: &talk}}},
+		{"moved, room and duration", map[string]TalkVersion{"a": talk}, map[string]TalkVersion{"a": moved},
+			[]TalkChange{{GUID: "a", Changes: []string{ChangeMoved, ChangeRoom, ChangeDuration}, Before: &talk, After: &moved}}},
+		{"ordered by start", map[string]TalkVersion{"a": talk}, map[string]TalkVersion{"b": other},
+			[]TalkChange{
+				{GUID: "b", Changes: []string{ChangeAdded}, After: &other},
+				{GUID: "a", Changes: []string{ChangeRemoved}, Before: &talk},
+			}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Diff(test.before, test.after); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
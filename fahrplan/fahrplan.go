@@ -13,13 +13,13 @@ import (
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
-func GetUpcoming(jobs map[int]PlayoutJob, when time.Duration) map[int]PlayoutJob {
-	upcoming := map[int]PlayoutJob{}
+func GetUpcoming(jobs map[string]PlayoutJob, when time.Duration) map[string]PlayoutJob {
+	upcoming := map[string]PlayoutJob{}
 	now := time.Now()
 	for _, job := range jobs {
 		if job.Start.After(now) && job.Start.Before(now.Add(when)) ||
 			now.After(job.Start) && now.Before(job.Start.Add(job.Duration)) {
-			upcoming[job.ID] = job
+			upcoming[job.GUID] = job
 		}
 	}
 	return upcoming
@@ -47,13 +47,13 @@ func GetSchedule(schedule *Fahrplan, url string) error {
 	return nil
 }
 
-func setNextRoomTalkStart(jobs map[int]PlayoutJob) map[int]PlayoutJob {
-	intermediate := make(map[string]map[time.Time]int)
+func setNextRoomTalkStart(jobs map[string]PlayoutJob) map[string]PlayoutJob {
+	intermediate := make(map[string]map[time.Time]string)
 	roomStartArray := make(map[string][]time.Time)
-	sorted := make(map[string][]int)
+	sorted := make(map[string][]string)
 	for id, job := range jobs {
 		if intermediate[job.Room] == nil {
-			intermediate[job.Room] = make(map[time.Time]int)
+			intermediate[job.Room] = make(map[time.Time]string)
 		}
 		if roomStartArray[job.Room] == nil {
 			roomStartArray[job.Room] = []time.Time{}
@@ -81,14 +81,50 @@ func setNextRoomTalkStart(jobs map[int]PlayoutJob) map[int]PlayoutJob {
 	return jobs
 }
 
-func ConvertScheduleToPLayoutJobs(schedule *Fahrplan, talkIDtoIngestURL map[int]string) map[int]PlayoutJob {
-	jobs := map[int]PlayoutJob{}
+func talkGUID(talk Talk) string {
+	if talk.GUID == "" {
+		return FallbackGUID(talk.ID)
+	}
+	return talk.GUID
+}
+
+func speakers(talk Talk) []string {
+	names := make([]string, 0, len(talk.Persons))
+	for _, p := range talk.Persons {
+		names = append(names, p.PublicName)
+	}
+	return names
+}
+
+// SourceResolver returns the ingest URL a talk has to be played out from.
+type SourceResolver interface {
+	IngestURL(talkID int, room string, start time.Time) (string, bool)
+}
+
+func ConvertScheduleToPLayoutJobs(schedule *Fahrplan, sources SourceResolver, filter *Filter, rooms *RoomMapper) (map[string]PlayoutJob, map[string]ExcludedTalk) {
+	jobs := map[string]PlayoutJob{}
+	excluded := map[string]ExcludedTalk{}
 	version := schedule.Schedule.Version
 
 	for _, day := range schedule.Schedule.Conference.Days {
-		for roomName, r := range day.Rooms {
+		for fahrplanRoom, r := range day.Rooms {
+			roomName := rooms.Resolve(fahrplanRoom)
 			for _, talk := range r {
-				if _, ok := talkIDtoIngestURL[talk.ID]; !ok {
+				reason := ""
+				source, hasSource := sources.IngestURL(talk.ID, roomName, talk.Date)
+				if ok, why := filter.Check(roomName, talk); !ok {
+					reason = why
+				} else if !hasSource {
+					reason = "no ingest URL mapped"
+				}
+				if reason != "" {
+					excluded[talkGUID(talk)] = ExcludedTalk{
+						GUID:   talkGUID(talk),
+						ID:     talk.ID,
+						Title:  talk.Title,
+						Room:   roomName,
+						Reason: reason,
+					}
 					continue
 				}
 				d := strings.Split(talk.Duration, ":")
@@ -109,16 +145,30 @@ func ConvertScheduleToPLayoutJobs(schedule *Fahrplan, talkIDtoIngestURL map[int]
 				}
 				duration := hour + minute
 				job := PlayoutJob{
+					GUID:     talkGUID(talk),
 					ID:       talk.ID,
 					Start:    talk.Date,
 					Duration: duration,
-					Source:   talkIDtoIngestURL[talk.ID],
+					Source:   source,
 					Version:  version,
 					Room:     roomName,
+					Day:      day.Index,
+					Title:    talk.Title,
+					Subtitle: talk.Subtitle,
+					Speakers: speakers(talk),
+					Track:    talk.Track,
+					Type:     talk.Type,
+					Language: talk.Language,
+					Slug:     talk.Slug,
+					URL:      talk.URL,
+				}
+				if existing, ok := jobs[job.GUID]; ok {
+					log.Printf("GUID %s of talk %d is already used by talk %d, skipping", job.GUID, talk.ID, existing.ID)
+					continue
 				}
-				jobs[talk.ID] = job
+				jobs[job.GUID] = job
 			}
 		}
 	}
-	return setNextRoomTalkStart(jobs)
+	return setNextRoomTalkStart(jobs), excluded
 }
@@ -0,0 +1,134 @@
+package fahrplan
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FilterRule matches a talk if every criterion which is set matches.
+// Title and Slug are regular expressions, all other fields are compared case-insensitively.
+type FilterRule struct {
+	Rooms     []string `yaml:"Rooms,omitempty"`
+	Tracks    []string `yaml:"Tracks,omitempty"`
+	Types     []string `yaml:"Types,omitempty"`
+	Languages []string `yaml:"Languages,omitempty"`
+	Title     string   `yaml:"Title,omitempty"`
+	Slug      string   `yaml:"Slug,omitempty"`
+}
+
+// FilterConfig decides which talks get converted into PlayoutJobs.
+// If Include is not empty a talk has to match at least one of its rules,
+// a talk matching any Exclude rule is always dropped.
+type FilterConfig struct {
+	Include           []FilterRule `yaml:"Include,omitempty"`
+	Exclude           []FilterRule `yaml:"Exclude,omitempty"`
+	HonourDoNotRecord bool         `yaml:"HonourDoNotRecord"`
+}
+
+type ExcludedTalk struct {
+	GUID   string `json:"guid"`
+	ID     int    `json:"id"`
+	Title  string `json:"title"`
+	Room   string `json:"room"`
+	Reason string `json:"reason"`
+}
+
+type filterRule struct {
+	FilterRule
+	title *regexp.Regexp
+	slug  *regexp.Regexp
+}
+
+type Filter struct {
+	include           []filterRule
+	exclude           []filterRule
+	honourDoNotRecord bool
+}
+
+func compileRules(kind string, rules []FilterRule) ([]filterRule, error) {
+	compiled := make([]filterRule, 0, len(rules))
+	for i, r := range rules {
+		c := filterRule{FilterRule: r}
+		var err error
+		if r.Title != "" {
+			if c.title, err = regexp.Compile(r.Title); err != nil {
+				return nil, fmt.Errorf("%s rule %d: invalid title pattern: %w", kind, i, err)
+			}
+		}
+		if r.Slug != "" {
+			if c.slug, err = regexp.Compile(r.Slug); err != nil {
+				return nil, fmt.Errorf("%s rule %d: invalid slug pattern: %w", kind, i, err)
+			}
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func NewFilter(cfg FilterConfig) (*Filter, error) {
+	include, err := compileRules("include", cfg.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := compileRules("exclude", cfg.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{include: include, exclude: exclude, honourDoNotRecord: cfg.HonourDoNotRecord}, nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r filterRule) match(room string, talk Talk) bool {
+	if len(r.Rooms) > 0 && !containsFold(r.Rooms, room) {
+		return false
+	}
+	if len(r.Tracks) > 0 && !containsFold(r.Tracks, talk.Track) {
+		return false
+	}
+	if len(r.Types) > 0 && !containsFold(r.Types, talk.Type) {
+		return false
+	}
+	if len(r.Languages) > 0 && !containsFold(r.Languages, talk.Language) {
+		return false
+	}
+	if r.title != nil && !r.title.MatchString(talk.Title) {
+		return false
+	}
+	if r.slug != nil && !r.slug.MatchString(talk.Slug) {
+		return false
+	}
+	return true
+}
+
+// Check returns whether talk should be played out and if not, why.
+func (f *Filter) Check(room string, talk Talk) (bool, string) {
+	if f == nil {
+		return true, ""
+	}
+	if f.honourDoNotRecord && talk.DoNotRecord {
+		return false, "do_not_record is set"
+	}
+	for i, r := range f.exclude {
+		if r.match(room, talk) {
+			return false, fmt.Sprintf("matches exclude rule %d", i)
+		}
+	}
+	if len(f.include) == 0 {
+		return true, ""
+	}
+	for _, r := range f.include {
+		if r.match(room, talk) {
+			return true, ""
+		}
+	}
+	return false, "matches no include rule"
+}
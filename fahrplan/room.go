@@ -0,0 +1,88 @@
+package fahrplan
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RoomAlias maps Fahrplan room names onto the name used for PlayoutServers and the studio files.
+// Exactly one of Match or Regex has to be set, IgnoreCase only applies to Match.
+type RoomAlias struct {
+	Room       string `yaml:"Room"`
+	Match      string `yaml:"Match,omitempty"`
+	IgnoreCase bool   `yaml:"IgnoreCase,omitempty"`
+	Regex      string `yaml:"Regex,omitempty"`
+}
+
+type roomAlias struct {
+	RoomAlias
+	re *regexp.Regexp
+}
+
+type RoomMapper struct {
+	aliases []roomAlias
+}
+
+func NewRoomMapper(aliases []RoomAlias) (*RoomMapper, error) {
+	m := &RoomMapper{}
+	for i, a := range aliases {
+		if a.Room == "" {
+			return nil, fmt.Errorf("room alias %d: no target room", i)
+		}
+		if (a.Match == "") == (a.Regex == "") {
+			return nil, fmt.Errorf("room alias %d: exactly one of Match or Regex is required", i)
+		}
+		alias := roomAlias{RoomAlias: a}
+		if a.Regex != "" {
+			re, err := regexp.Compile(a.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("room alias %d: %w", i, err)
+			}
+			alias.re = re
+		}
+		m.aliases = append(m.aliases, alias)
+	}
+	return m, nil
+}
+
+// Resolve returns the canonical name of room. Exact matches win over
+// case-insensitive ones, which win over regular expressions.
+func (m *RoomMapper) Resolve(room string) string {
+	if m == nil {
+		return room
+	}
+	for _, a := range m.aliases {
+		if a.Match != "" && !a.IgnoreCase && a.Match == room {
+			return a.Room
+		}
+	}
+	for _, a := range m.aliases {
+		if a.Match != "" && a.IgnoreCase && strings.EqualFold(a.Match, room) {
+			return a.Room
+		}
+	}
+	for _, a := range m.aliases {
+		if a.re != nil && a.re.MatchString(room) {
+			return a.Room
+		}
+	}
+	return room
+}
+
+// Rooms returns the sorted, resolved names of all rooms in schedule.
+func Rooms(schedule *Fahrplan, rooms *RoomMapper) []string {
+	seen := map[string]bool{}
+	for _, day := range schedule.Schedule.Conference.Days {
+		for name := range day.Rooms {
+			seen[rooms.Resolve(name)] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,20 @@
+package fahrplan
+
+import "time"
+
+// Shift moves every job of room starting at or after from by offset.
+// Next is shifted the same way, so the padding of the job before from stays correct.
+func Shift(jobs map[string]PlayoutJob, room string, from time.Time, offset time.Duration) {
+	for id, job := range jobs {
+		if job.Room != room {
+			continue
+		}
+		if !job.Start.Before(from) {
+			job.Start = job.Start.Add(offset)
+		}
+		if !job.Next.IsZero() && !job.Next.Before(from) {
+			job.Next = job.Next.Add(offset)
+		}
+		jobs[id] = job
+	}
+}
@@ -1,10 +1,14 @@
 package fahrplan
 
 import (
+	"hash/fnv"
+	"math"
+	"strconv"
 	"time"
 )
 
 type PlayoutJob struct {
+	GUID     string        `json:"guid"`
 	ID       int           `json:"id"`
 	Start    time.Time     `json:"start"`
 	Duration time.Duration `json:"duration"`
@@ -12,6 +16,29 @@ type PlayoutJob struct {
 	Version  string        `json:"version"`
 	Room     string        `json:"room"`
 	Next     time.Time     `json:"next"`
+	Day      int           `json:"day"`
+	Title    string        `json:"title"`
+	Subtitle string        `json:"subtitle"`
+	Speakers []string      `json:"speakers"`
+	Track    string        `json:"track"`
+	Type     string        `json:"type"`
+	Language string        `json:"language"`
+	Slug     string        `json:"slug"`
+	URL      string        `json:"url"`
+}
+
+// PlayoutID maps the GUID onto the int64 job ID the playout servers expect.
+// The value only depends on the GUID, so it stays stable across re-imports.
+// Jobs without a GUID get a FallbackGUID when they are imported or submitted.
+func (j PlayoutJob) PlayoutID() int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(j.GUID))
+	return int64(h.Sum64() & math.MaxInt64)
+}
+
+// FallbackGUID is used as identity for talks and jobs which come without a GUID.
+func FallbackGUID(id int) string {
+	return "id:" + strconv.Itoa(id)
 }
 
 type Fahrplan struct {
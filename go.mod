@@ -4,17 +4,18 @@ go 1.15
 
 require (
 	github.com/Garionion/ffmpeg-playout v0.2.0
+	github.com/eclipse/paho.mqtt.golang v1.3.1
 	github.com/gofiber/fiber/v2 v2.3.2
 	github.com/golang/protobuf v1.4.3
-	github.com/grafov/bcast v0.0.0-20190217190352-1447f067e08d
 	github.com/ilyakaznacheev/cleanenv v1.2.5
 	github.com/json-iterator/go v1.1.10
 	github.com/klauspost/compress v1.11.4 // indirect
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.1 // indirect
 	golang.org/x/sys v0.0.0-20201223074533-0d417f636930 // indirect
 	google.golang.org/grpc v1.34.0
-	gopkg.in/fatih/set.v0 v0.2.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 	olympos.io/encoding/edn v0.0.0-20201019073823-d3554ca0b0a3 // indirect
 )
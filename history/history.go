@@ -0,0 +1,190 @@
+package history
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/Garionion/playout-controller/fahrplan"
+	jsoniter "github.com/json-iterator/go"
+	_ "github.com/mattn/go-sqlite3"
+	"strings"
+	"time"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+var ErrUnknownVersion = errors.New("unknown Fahrplan version")
+
+const schema = `
+CREATE TABLE IF NOT EXISTS versions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	version TEXT NOT NULL,
+	fetched_at TIMESTAMP NOT NULL,
+	talk_count INTEGER NOT NULL,
+	talks TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS changes (
+	version_id INTEGER NOT NULL REFERENCES versions(id),
+	guid TEXT NOT NULL,
+	changes TEXT NOT NULL,
+	before TEXT,
+	after TEXT
+);
+CREATE INDEX IF NOT EXISTS changes_version ON changes(version_id);
+`
+
+// Version is a recorded Fahrplan version. Changes counts the talks which differ from the previous one.
+type Version struct {
+	ID        int64     `json:"id"`
+	Version   string    `json:"version"`
+	FetchedAt time.Time `json:"fetchedAt"`
+	Talks     int       `json:"talks"`
+	Changes   int       `json:"changes"`
+}
+
+// History records every distinct Fahrplan in an SQLite database.
+type History struct {
+	db *sql.DB
+}
+
+func Open(file string) (*History, error) {
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only supports one writer at a time
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	return &History{db: db}, nil
+}
+
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+func (h *History) talks(query string, args ...interface{}) (int64, string, map[string]fahrplan.TalkVersion, error) {
+	var id int64
+	var version, data string
+	err := h.db.QueryRow(query, args...).Scan(&id, &version, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, "", nil, ErrUnknownVersion
+	}
+	if err != nil {
+		return 0, "", nil, err
+	}
+	talks := map[string]fahrplan.TalkVersion{}
+	if err := json.Unmarshal([]byte(data), &talks); err != nil {
+		return 0, "", nil, fmt.Errorf("version %d: %w", id, err)
+	}
+	return id, version, talks, nil
+}
+
+// Record stores talks as a new version unless neither the version nor any talk changed
+// since the latest one. It returns the changes to the previous version.
+func (h *History) Record(version string, fetchedAt time.Time, talks map[string]fahrplan.TalkVersion) ([]fahrplan.TalkChange, bool, error) {
+	_, latestVersion, latest, err := h.talks("SELECT id, version, talks FROM versions ORDER BY id DESC LIMIT 1")
+	if err != nil && !errors.Is(err, ErrUnknownVersion) {
+		return nil, false, err
+	}
+	changes := fahrplan.Diff(latest, talks)
+	if latest != nil && latestVersion == version && len(changes) == 0 {
+		return nil, false, nil
+	}
+	data, err := json.Marshal(talks)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	res, err := tx.Exec("INSERT INTO versions (version, fetched_at, talk_count, talks) VALUES (?, ?, ?, ?)",
+		version, fetchedAt, len(talks), string(data))
+	if err != nil {
+		tx.Rollback()
+		return nil, false, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return nil, false, err
+	}
+	for _, c := range changes {
+		before, err := json.Marshal(c.Before)
+		if err != nil {
+			tx.Rollback()
+			return nil, false, err
+		}
+		after, err := json.Marshal(c.After)
+		if err != nil {
+			tx.Rollback()
+			return nil, false, err
+		}
+		if _, err := tx.Exec("INSERT INTO changes (version_id, guid, changes, before, after) VALUES (?, ?, ?, ?, ?)",
+			id, c.GUID, strings.Join(c.Changes, ","), string(before), string(after)); err != nil {
+			tx.Rollback()
+			return nil, false, err
+		}
+	}
+	return changes, true, tx.Commit()
+}
+
+// Versions lists all recorded versions, oldest first.
+func (h *History) Versions() ([]Version, error) {
+	rows, err := h.db.Query(`SELECT v.id, v.version, v.fetched_at, v.talk_count,
+		(SELECT COUNT(*) FROM changes c WHERE c.version_id = v.id) FROM versions v ORDER BY v.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := []Version{}
+	for rows.Next() {
+		var v Version
+		if err := rows.Scan(&v.ID, &v.Version, &v.FetchedAt, &v.Talks, &v.Changes); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// Latest returns the id of the newest version.
+func (h *History) Latest() (int64, error) {
+	var id sql.NullInt64
+	if err := h.db.QueryRow("SELECT MAX(id) FROM versions").Scan(&id); err != nil {
+		return 0, err
+	}
+	if !id.Valid {
+		return 0, ErrUnknownVersion
+	}
+	return id.Int64, nil
+}
+
+// Previous returns the id of the version recorded before id.
+func (h *History) Previous(id int64) (int64, error) {
+	var prev sql.NullInt64
+	if err := h.db.QueryRow("SELECT MAX(id) FROM versions WHERE id < ?", id).Scan(&prev); err != nil {
+		return 0, err
+	}
+	if !prev.Valid {
+		return 0, ErrUnknownVersion
+	}
+	return prev.Int64, nil
+}
+
+// Diff compares the talks of the versions from and to.
+func (h *History) Diff(from int64, to int64) ([]fahrplan.TalkChange, error) {
+	_, _, before, err := h.talks("SELECT id, version, talks FROM versions WHERE id = ?", from)
+	if err != nil {
+		return nil, fmt.Errorf("%d: %w", from, err)
+	}
+	_, _, after, err := h.talks("SELECT id, version, talks FROM versions WHERE id = ?", to)
+	if err != nil {
+		return nil, fmt.Errorf("%d: %w", to, err)
+	}
+	return fahrplan.Diff(before, after), nil
+}
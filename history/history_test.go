@@ -0,0 +1,83 @@
+package history
+
+import (
+	"errors"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	h, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if _, err := h.Latest(); !errors.Is(err, ErrUnknownVersion) {
+		t.Fatalf("empty history: got %v, want %v", err, ErrUnknownVersion)
+	}
+
+	start := time.Date(2020, 12, 27, 10, 0, 0, 0, time.UTC)
+	talk := fahrplan.TalkVersion{GUID: "a", ID: 1, Title: "Opening", Room: "Saal 1", Start: start, Duration: "00:30"}
+	v1 := map[string]fahrplan.TalkVersion{"a": talk}
+	moved := talk
+	moved.Start = start.Add(time.Hour)
+	v2 := map[string]fahrplan.TalkVersion{"a": moved}
+
+	changes, recorded, err := h.Record("1.0", start, v1)
+	if err != nil || !recorded || len(changes) != 1 || changes[0].Changes[0] != fahrplan.ChangeAdded {
+		t.Fatalf("first version: got %+v, %v, %v", changes, recorded, err)
+	}
+	if _, recorded, err := h.Record("1.0", start.Add(time.Minute), v1); err != nil || recorded {
+		t.Fatalf("unchanged version: recorded %v, %v", recorded, err)
+	}
+	changes, recorded, err = h.Record("1.1", start.Add(2*time.Minute), v2)
+	if err != nil || !recorded {
+		t.Fatalf("second version: recorded %v, %v", recorded, err)
+	}
+	want := []fahrplan.TalkChange{{GUID: "a", Changes: []string{fahrplan.ChangeMoved}, Before: &talk, After: &moved}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("second version: got %+v, want %+v", changes, want)
+	}
+
+	versions, err := h.Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("got %d versions, want 2", len(versions))
+	}
+	for i, want := range []Version{
+		{ID: 1, Version: "1.0", FetchedAt: start, Talks: 1, Changes: 1},
+		{ID: 2, Version: "1.1", FetchedAt: start.Add(2 * time.Minute), Talks: 1, Changes: 1},
+	} {
+		got := versions[i]
+		if got.ID != want.ID || got.Version != want.Version || !got.FetchedAt.Equal(want.FetchedAt) ||
+			got.Talks != want.Talks || got.Changes != want.Changes {
+			t.Errorf("version %d: got %+v, want %+v", i, got, want)
+		}
+	}
+
+	latest, err := h.Latest()
+	if err != nil || latest != 2 {
+		t.Fatalf("got latest %d, %v, want 2", latest, err)
+	}
+	prev, err := h.Previous(latest)
+	if err != nil || prev != 1 {
+		t.Fatalf("got previous %d, %v, want 1", prev, err)
+	}
+	if _, err := h.Previous(prev); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("previous of the first version: got %v, want %v", err, ErrUnknownVersion)
+	}
+	diff, err := h.Diff(prev, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("got diff %+v, want %+v", diff, want)
+	}
+	if _, err := h.Diff(1, 3); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("diff to an unknown version: got %v, want %v", err, ErrUnknownVersion)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"github.com/Garionion/playout-controller/history"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+)
+
+// versionParam returns the version id in query parameter name, ok is false if it is missing.
+func versionParam(c *fiber.Ctx, name string) (id int64, ok bool, err error) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, false, nil
+	}
+	id, err = strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false, fiber.NewError(fiber.StatusBadRequest, name+" has to be a version id")
+	}
+	return id, true, nil
+}
+
+func historyError(err error) error {
+	if errors.Is(err, history.ErrUnknownVersion) {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	return err
+}
+
+func registerHistoryRoutes(api fiber.Router, h *history.History) {
+	disabled := func(c *fiber.Ctx) error {
+		if h == nil {
+			return fiber.NewError(fiber.StatusNotFound, "Fahrplan history is disabled")
+		}
+		return c.Next()
+	}
+	api.Get("/fahrplan/versions", disabled, func(c *fiber.Ctx) error {
+		versions, err := h.Versions()
+		if err != nil {
+			return err
+		}
+		return c.JSON(versions)
+	})
+	// /fahrplan/diff compares two versions, to defaults to the latest
+	// and from to the version before to.
+	api.Get("/fahrplan/diff", disabled, func(c *fiber.Ctx) error {
+		to, ok, err := versionParam(c, "to")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if to, err = h.Latest(); err != nil {
+				return historyError(err)
+			}
+		}
+		from, ok, err := versionParam(c, "from")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if from, err = h.Previous(to); err != nil {
+				return historyError(err)
+			}
+		}
+		changes, err := h.Diff(from, to)
+		if err != nil {
+			return historyError(err)
+		}
+		return c.JSON(fiber.Map{"from": from, "to": to, "changes": changes})
+	})
+}
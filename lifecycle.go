@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/protobuf/ptypes"
+	"log"
+	"net/url"
+	"time"
+)
+
+const (
+	lifecycleInterval  = 5 * time.Second
+	lifecycleRetention = 24 * time.Hour
+)
+
+func setJobState(s *store.Store, job fahrplan.PlayoutJob, state store.JobState, reason string) {
+	if err := s.SetJobState(job, state, reason); err != nil {
+		log.Printf("Lifecycle: %v", err)
+	}
+}
+
+// setJobSubmitted moves job to submitted and returns the state it was in before.
+func setJobSubmitted(s *store.Store, job fahrplan.PlayoutJob, reason string) store.JobState {
+	previous := s.Lifecycles()[job.GUID].State
+	setJobState(s, job, store.StateSubmitted, reason)
+	return previous
+}
+
+// serverDown reports whether the connection to a playout server is broken.
+// The playout servers do not report the state of their jobs, so a job counts as
+// due while its server is reachable during its playout time.
+func serverDown(state string) bool {
+	return state == "TRANSIENT_FAILURE" || state == "SHUTDOWN"
+}
+
+// updateLifecycles advances the jobs whose state follows from the time, the Fahrplan
+// and the connections to the playout servers.
+func updateLifecycles(s *store.Store, now time.Time) {
+	snapshot := s.Snapshot()
+	servers := s.ServerStates()
+	job := func(guid string, room string) fahrplan.PlayoutJob {
+		if j, ok := snapshot.PlayoutJobs[guid]; ok {
+			return j
+		}
+		if j, ok := s.Submitted()[guid]; ok {
+			return j
+		}
+		return fahrplan.PlayoutJob{GUID: guid, Room: room}
+	}
+
+	for guid, j := range snapshot.Upcoming {
+		if _, ok := snapshot.Lifecycles[guid]; !ok {
+			setJobState(s, j, store.StatePlanned, "upcoming")
+		}
+	}
+	for guid, l := range snapshot.Lifecycles {
+		switch l.State {
+		case store.StatePlanned:
+			j, ok := snapshot.PlayoutJobs[guid]
+			if !ok {
+				setJobState(s, job(guid, l.Room), store.StateCancelled, "removed from the Fahrplan")
+			} else if !now.Before(j.Start.Add(j.Duration)) {
+				setJobState(s, j, store.StateCancelled, "never scheduled")
+			}
+		case store.StateAccepted, store.StateDue:
+			sj, ok := snapshot.Scheduled[guid]
+			if !ok {
+				continue
+			}
+			start, err := ptypes.Timestamp(sj.StartAt)
+			if err != nil {
+				continue
+			}
+			stop, err := ptypes.Timestamp(sj.StopAt)
+			if err != nil {
+				continue
+			}
+			switch {
+			case !now.Before(stop):
+				setJobState(s, job(guid, l.Room), store.StatePast, "playout time is over")
+			case now.Before(start):
+			case serverDown(servers[l.Room]):
+				setJobState(s, job(guid, l.Room), store.StateFailed, "playout server is unreachable")
+			case l.State == store.StateAccepted:
+				setJobState(s, job(guid, l.Room), store.StateDue, "playout time started")
+			}
+		}
+	}
+	s.PruneLifecycles(now.Add(-lifecycleRetention))
+	expireManualActions(s, now, lifecycleRetention)
+}
+
+func trackLifecycles(ctx context.Context, s *store.Store) chan struct{} {
+	ticker := time.NewTicker(lifecycleInterval)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ticker.C:
+				updateLifecycles(s, time.Now())
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return done
+}
+
+func registerLifecycleRoutes(api fiber.Router, s *store.Store) {
+	api.Get("/jobs/states", func(c *fiber.Ctx) error {
+		return c.JSON(s.Lifecycles())
+	})
+	api.Get("/jobs/:guid/state", func(c *fiber.Ctx) error {
+		guid, err := url.PathUnescape(c.Params("guid"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		l, ok := s.Lifecycles()[guid]
+		if !ok {
+			return fiber.NewError(fiber.StatusNotFound, "unknown job "+guid)
+		}
+		return c.JSON(l)
+	})
+}
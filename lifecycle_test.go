@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/Garionion/playout-controller/store/storetest"
+	"testing"
+	"time"
+)
+
+func TestUpdateLifecycles(t *testing.T) {
+	s := storetest.New(t)
+	now := time.Now()
+	job := func(guid string, start time.Time) fahrplan.PlayoutJob {
+		return fahrplan.PlayoutJob{GUID: guid, Room: "Saal 1", Start: start, Duration: 30 * time.Minute}
+	}
+	jobs := map[string]fahrplan.PlayoutJob{
+		"upcoming": job("upcoming", now.Add(10*time.Minute)),
+		"missed":   job("missed", now.Add(-time.Hour)),
+		"starting": job("starting", now.Add(-time.Minute)),
+		"waiting":  job("waiting", now.Add(5*time.Minute)),
+		"over":     job("over", now.Add(-time.Hour)),
+	}
+	s.SetPlayoutJobs(jobs)
+	s.SetUpcomingJobs(map[string]fahrplan.PlayoutJob{"upcoming": jobs["upcoming"]})
+	s.UpdateScheduled(func(scheduled map[string]api.ScheduledJob) {
+		scheduled["starting"] = storetest.ScheduledJob(t, "Saal 1", now.Add(-time.Minute), now.Add(time.Hour))
+		scheduled["waiting"] = storetest.ScheduledJob(t, "Saal 1", now.Add(5*time.Minute), now.Add(time.Hour))
+		scheduled["over"] = storetest.ScheduledJob(t, "Saal 1", now.Add(-time.Hour), now.Add(-time.Minute))
+	})
+	walk := func(j fahrplan.PlayoutJob, states ...store.JobState) {
+		for _, state := range states {
+			if err := s.SetJobState(j, state, "test"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	walk(fahrplan.PlayoutJob{GUID: "removed", Room: "Saal 1"}, store.StatePlanned)
+	walk(jobs["missed"], store.StatePlanned)
+	walk(jobs["starting"], store.StateSubmitted, store.StateAccepted)
+	walk(jobs["waiting"], store.StateSubmitted, store.StateAccepted)
+	walk(jobs["over"], store.StateSubmitted, store.StateAccepted, store.StateDue)
+
+	updateLifecycles(s, now)
+
+	lifecycles := s.Lifecycles()
+	for guid, want := range map[string]struct {
+		state  store.JobState
+		reason string
+	}{
+		"upcoming": {store.StatePlanned, "upcoming"},
+		"removed":  {store.StateCancelled, "removed from the Fahrplan"},
+		"missed":   {store.StateCancelled, "never scheduled"},
+		"starting": {store.StateDue, "playout time started"},
+		"waiting":  {store.StateAccepted, "test"},
+		"over":     {store.StatePast, "playout time is over"},
+	} {
+		l, ok := lifecycles[guid]
+		if !ok {
+			t.Errorf("%s: no lifecycle", guid)
+			continue
+		}
+		last := l.Transitions[len(l.Transitions)-1]
+		if l.State != want.state || last.Reason != want.reason {
+			t.Errorf("%s: got %s (%s), want %s (%s)", guid, l.State, last.Reason, want.state, want.reason)
+		}
+	}
+
+	// the state only depends on the time, so another update changes nothing
+	rev := s.Revision()
+	updateLifecycles(s, now)
+	if s.Revision() != rev {
+		t.Errorf("second update changed the store")
+	}
+}
+
+func TestExpireManualActions(t *testing.T) {
+	s := storetest.New(t)
+	now := time.Now()
+	s.SetPlayoutJobs(map[string]fahrplan.PlayoutJob{
+		"ended":   {GUID: "ended", Start: now.Add(-2 * time.Hour), Duration: time.Hour},
+		"delayed": {GUID: "delayed", Start: now.Add(-2 * time.Hour), Duration: time.Hour},
+		"running": {GUID: "running", Start: now.Add(-time.Minute), Duration: time.Hour},
+	})
+	s.UpdateScheduled(func(scheduled map[string]api.ScheduledJob) {
+		scheduled["delayed"] = storetest.ScheduledJob(t, "Saal 1", now.Add(-2*time.Hour), now.Add(time.Minute))
+	})
+	for guid, at := range map[string]time.Time{
+		"ended":          now.Add(-2 * time.Hour),
+		"delayed":        now.Add(-2 * time.Hour),
+		"running":        now.Add(-time.Minute),
+		"unknown":        now.Add(-2 * lifecycleRetention),
+		"recent unknown": now.Add(-time.Hour),
+	} {
+		if err := s.SetManualAction(guid, store.ManualAction{Action: store.ActionLive, At: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expireManualActions(s, now, lifecycleRetention)
+
+	actions := s.ManualActions()
+	for guid, want := range map[string]bool{"ended": false, "delayed": true, "running": true, "unknown": false, "recent unknown": true} {
+		if _, ok := actions[guid]; ok != want {
+			t.Errorf("%s: kept %v, want %v", guid, ok, want)
+		}
+	}
+}
@@ -1,93 +1,180 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"github.com/Garionion/playout-controller/events"
 	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/history"
+	"github.com/Garionion/playout-controller/metrics"
+	"github.com/Garionion/playout-controller/mqtt"
 	"github.com/Garionion/playout-controller/store"
+	"github.com/Garionion/playout-controller/studio"
+	"github.com/Garionion/playout-controller/webhook"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/grafov/bcast"
-	"github.com/ilyakaznacheev/cleanenv"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-type Configuration struct {
-	Address             string            `yaml:"Address" env:"ADDRESS"`
-	FahrplanURL         string            `yaml:"FahrplanUrl" env:"FAHRPLAN_URL"`
-	Fahrplanrefresh     time.Duration     `yaml:"Fahrplanrefresh" env:"FAHRPLAN_REFRESH"`
-	AutoSchedule        bool              `yaml:"AutoSchedule" env:"AUTOSCHEDULE"`
-	UpcomingInterval    time.Duration     `yaml:"UpcomingInterval" env:"UPCOMINGINTERVAL"`
-	PrePadding          time.Duration     `yaml:"PrePadding"`
-	MaxPostPadding      time.Duration     `yaml:"MaxPostPadding"`
-	IngestServer        IngestServer      `yaml:"IngestServer"`
-	PlayoutServers      map[string]string `yaml:"PlayoutServers"`
-	StudioIngestURLFile string            `yaml:"StudioIngestURLFile"`
-	TalkIDtoStudioFile  string            `yaml:"TalkIDtoStudioFile"`
-}
-type IngestServer struct {
-	Nginx   []string `yaml:"nginx,omitempty"`
-	Icecast []string `yaml:"icecast,omitempty"`
+func reportUnmappedRooms(cfg *Configuration, schedule *fahrplan.Fahrplan) {
+	for _, room := range fahrplan.Rooms(schedule, cfg.rooms) {
+		if _, ok := cfg.PlayoutServers[room]; !ok {
+			log.Printf("Fahrplan room %q has no playout server", room)
+		}
+	}
 }
 
-func getJobs(fahrplanURL string, version string, talkIDtoIngestURL map[int]string) (string, map[int]fahrplan.PlayoutJob) {
+func getSchedule(cfg *Configuration, version string) (*fahrplan.Fahrplan, error) {
 	schedule := new(fahrplan.Fahrplan)
-	if err := fahrplan.GetSchedule(schedule, fahrplanURL); err != nil {
-		log.Printf("Failed to get Fahrplan: %v", err)
+	if err := fahrplan.GetSchedule(schedule, cfg.FahrplanURL); err != nil {
+		return nil, err
 	}
 	if schedule.Schedule.Version == version {
 		log.Printf("Fahrplan version %s is still up to date\n", version)
 	} else {
 		log.Printf("NEW Fahrplan version %s", schedule.Schedule.Version)
+		reportUnmappedRooms(cfg, schedule)
+	}
+	return schedule, nil
+}
+
+// recordHistory stores schedule as a new Fahrplan version if it differs from the last one
+// and returns the changed talks.
+func recordHistory(cfg *Configuration, h *history.History, schedule *fahrplan.Fahrplan) []fahrplan.TalkChange {
+	if h == nil || schedule.Schedule.Version == "" {
+		return nil
+	}
+	changes, recorded, err := h.Record(schedule.Schedule.Version, time.Now(), fahrplan.Talks(schedule, cfg.rooms))
+	if err != nil {
+		log.Printf("Failed to record Fahrplan history: %v", err)
+		return nil
+	}
+	if recorded {
+		log.Printf("Recorded Fahrplan version %s with %d changed talks", schedule.Schedule.Version, len(changes))
+	}
+	return changes
+}
+
+func getJobs(cfg *Configuration, store *store.Store, schedule *fahrplan.Fahrplan, mapping *studio.Mapping) map[string]fahrplan.PlayoutJob {
+	jobs, excluded := fahrplan.ConvertScheduleToPLayoutJobs(schedule, mapping, cfg.filter, cfg.rooms)
+	store.SetExcludedTalks(excluded)
+	for room, offset := range store.RoomOffsets() {
+		fahrplan.Shift(jobs, room, offset.From, offset.Offset)
+	}
+	return jobs
+}
+
+func publishJobs(s *store.Store, jobs map[string]fahrplan.PlayoutJob) {
+	s.Events().Publish(events.Event{Type: events.FahrplanUpdated, Jobs: jobs})
+}
+
+// resetTicker applies a changed interval to ticker.
+func resetTicker(ticker *time.Ticker, current *time.Duration, interval time.Duration) {
+	if interval != *current {
+		ticker.Reset(interval)
+		*current = interval
 	}
-	jobs := fahrplan.ConvertScheduleToPLayoutJobs(schedule, talkIDtoIngestURL)
-	return schedule.Schedule.Version, jobs
 }
 
-func refreshFahrplan(cfg *Configuration, talkIDtoIngestURL map[int]string, jobChannel *bcast.Member) {
-	ticker := time.NewTicker(cfg.Fahrplanrefresh)
-	quit := make(chan struct{})
+func refreshFahrplan(ctx context.Context, config *configHolder, store *store.Store, mappings *studio.Watcher, h *history.History) chan struct{} {
+	interval := config.Get().Fahrplanrefresh
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
 
-	go func(fahrplanURL string) {
-		version, jobs := getJobs(fahrplanURL, "", talkIDtoIngestURL)
-		jobChannel.Send(jobs)
+	go func() {
+		defer close(done)
+		// schedule is nil until the first successful fetch. A failed fetch keeps the last
+		// schedule, as publishing no jobs would cancel all of them.
+		var schedule *fahrplan.Fahrplan
+		var version string
+		publish := func(cfg *Configuration) {
+			if schedule != nil {
+				publishJobs(store, getJobs(cfg, store, schedule, mappings.Mapping()))
+			}
+		}
+		fetch := func(cfg *Configuration) {
+			next, err := getSchedule(cfg, version)
+			if err != nil {
+				log.Printf("Failed to get Fahrplan: %v", err)
+				return
+			}
+			changes := recordHistory(cfg, h, next)
+			if v := next.Schedule.Version; v != "" && v != version {
+				if version != "" {
+					store.Events().Publish(events.Event{Type: events.VersionChanged, Version: v, Changes: changes})
+				}
+				version = v
+			}
+			schedule = next
+			publish(cfg)
+		}
+		fetch(config.Get())
 		for {
 			select {
 			case <-ticker.C:
-				version, jobs = getJobs(fahrplanURL, version, talkIDtoIngestURL)
-				jobChannel.Send(jobs)
-			case <-quit:
+				cfg := config.Get()
+				resetTicker(ticker, &interval, cfg.Fahrplanrefresh)
+				fetch(cfg)
+			case <-mappings.Changed():
+				log.Println("Mapping changed, updating job sources")
+				publish(config.Get())
+			case <-store.OffsetsChanged():
+				// the scheduler resubmits the jobs which got shifted
+				publish(config.Get())
+			case <-ctx.Done():
 				ticker.Stop()
 				return
 			}
 		}
-	}(cfg.FahrplanURL)
+	}()
+	return done
 }
 
-func getUpcoming(cfg *Configuration, store *store.Store, jobChannel *bcast.Member, upcomingChannel *bcast.Member) chan struct{} {
-	interval := minOfDuration(cfg.UpcomingInterval/4, cfg.Fahrplanrefresh)
+func upcomingInterval(cfg *Configuration) time.Duration {
+	return minOfDuration(cfg.UpcomingInterval/4, cfg.Fahrplanrefresh)
+}
+
+func getUpcoming(ctx context.Context, config *configHolder, store *store.Store) chan struct{} {
+	interval := upcomingInterval(config.Get())
 
+	sub := store.Events().Subscribe("upcoming", 1, events.FahrplanUpdated)
 	ticker := time.NewTicker(interval)
-	quit := make(chan struct{})
+	done := make(chan struct{})
 	go func() {
-		jobs := jobChannel.Recv().(map[int]fahrplan.PlayoutJob)
-		upcoming := fahrplan.GetUpcoming(jobs, cfg.UpcomingInterval)
-		upcomingChannel.Send(upcoming)
+		defer close(done)
+		select {
+		case e := <-sub.Events():
+			sub.Close()
+			upcoming := fahrplan.GetUpcoming(e.Jobs, config.Get().UpcomingInterval)
+			publishUpcoming(store, upcoming)
+		case <-ctx.Done():
+			sub.Close()
+			ticker.Stop()
+			return
+		}
 		for {
 			select {
 			case <-ticker.C:
-				store.RLock()
-				jobs := store.PlayoutJobs
-				store.RUnlock()
-				upcoming := fahrplan.GetUpcoming(jobs, cfg.UpcomingInterval)
-				upcomingChannel.Send(upcoming)
-			case <-quit:
+				cfg := config.Get()
+				resetTicker(ticker, &interval, upcomingInterval(cfg))
+				upcoming := fahrplan.GetUpcoming(store.PlayoutJobs(), cfg.UpcomingInterval)
+				publishUpcoming(store, upcoming)
+			case <-ctx.Done():
 				ticker.Stop()
 				return
 			}
 		}
 	}()
-	return quit
+	return done
+}
+
+func publishUpcoming(s *store.Store, upcoming map[string]fahrplan.PlayoutJob) {
+	s.Events().Publish(events.Event{Type: events.UpcomingChanged, Jobs: upcoming})
 }
 
 func minOfDuration(d1 time.Duration, d2 time.Duration) time.Duration {
@@ -98,27 +185,62 @@ func minOfDuration(d1 time.Duration, d2 time.Duration) time.Duration {
 }
 
 func main() {
-	cfg := new(Configuration)
-	jobChannel := bcast.NewGroup()
-	go jobChannel.Broadcast(0)
-	upcomingChannel := bcast.NewGroup()
-	go upcomingChannel.Broadcast(0)
-	scheduledChannel := bcast.NewGroup()
-	go scheduledChannel.Broadcast(0)
-	err := cleanenv.ReadConfig("config.yml", cfg)
+	if len(os.Args) > 1 && os.Args[1] == "validate-mappings" {
+		os.Exit(validateMappings(os.Args[2:]))
+	}
+
+	configFile := flag.String("config", "config.yml", "path to the configuration file")
+	flag.Parse()
+
+	bus := events.NewBus()
+	cfg, err := loadConfig(*configFile)
 	if err != nil {
 		log.Fatal("Failed to load Config: ", err)
 	}
+	config := newConfigHolder(cfg)
 
-	s, _ := store.NewStore(jobChannel.Join(), upcomingChannel.Join(), scheduledChannel.Join(), cfg.PlayoutServers)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
-	talkToIngestURL := getTalkIngestURL(cfg.TalkIDtoStudioFile, cfg.StudioIngestURLFile)
-	refreshFahrplan(cfg, talkToIngestURL, jobChannel.Join())
+	s, storeDone, _ := store.NewStore(ctx, bus, cfg.PlayoutServers)
+	if err := s.LoadState(cfg.StateFile); err != nil {
+		log.Fatal("Failed to load state: ", err)
+	}
+
+	mappings, err := studio.NewWatcher(cfg.mappingFiles())
+	if err != nil {
+		log.Fatal("Failed to load mapping: ", err)
+	}
+	var h *history.History
+	if cfg.HistoryFile != "" {
+		if h, err = history.Open(cfg.HistoryFile); err != nil {
+			log.Fatal("Failed to open history: ", err)
+		}
+	}
+	loops := []chan struct{}{
+		storeDone,
+		watchConfig(ctx, *configFile, config, s, hup),
+		mappings.Watch(ctx, cfg.MappingRefresh),
+		getUpcoming(ctx, config, s),
+		scheduler(ctx, config, s),
+		trackLifecycles(ctx, s),
+		webhook.Run(ctx, bus, func() []*webhook.Hook { return config.Get().webhooks }),
+		refreshFahrplan(ctx, config, s, mappings, h),
+	}
 
-	getUpcoming(cfg, s, jobChannel.Join(), upcomingChannel.Join())
-	scheduler(cfg, s, upcomingChannel.Join(), scheduledChannel.Join())
+	if cfg.MQTT.Broker != "" {
+		done, err := mqtt.Run(ctx, cfg.MQTT, s)
+		if err != nil {
+			log.Fatal("Failed to connect to MQTT broker: ", err)
+		}
+		loops = append(loops, done)
+	}
 
-	log.Printf("%v\n", cfg)
+	log.Printf("%v\n", cfg.redacted())
 
 	app := fiber.New()
 	app.Use(cors.New())
@@ -127,44 +249,84 @@ func main() {
 
 	api := app.Group("/api")
 	api.Get("/all", func(c *fiber.Ctx) error {
-		s.RLock()
-		p := s.PlayoutJobs
-		s.RUnlock()
-		return c.JSON(p)
+		return c.JSON(s.PlayoutJobs())
 	})
 	api.Get("/upcoming", func(c *fiber.Ctx) error {
-		s.RLock()
-		u := s.Upcoming
-		s.RUnlock()
-		return c.JSON(u)
+		return c.JSON(s.Upcoming())
 	})
 	api.Get("/scheduled", func(c *fiber.Ctx) error {
-		s.RLock()
-		scheduled := s.Scheduled
-		s.RUnlock()
-		return c.JSON(scheduled)
+		return c.JSON(scheduledJobs(s))
 	})
-	api.Post("/schedulePlayout", func(ctx *fiber.Ctx) error {
-		job := new(fahrplan.PlayoutJob)
-		jsonErr := json.Unmarshal(ctx.Body(), job)
-		if jsonErr != nil {
-			log.Println("got defective request: ", jsonErr)
-			ctx.SendStatus(400)
-			return jsonErr
-		}
-		pjobs := make(map[int]fahrplan.PlayoutJob)
-		pjobs[job.ID] = *job
-		s.RLock()
-		scheduled := s.Scheduled
-		s.RUnlock()
-		newScheduled := schedule(cfg, s, pjobs, scheduled, false)
-		s.SetScheduledJobs(newScheduled)
-		ctx.JSON(newScheduled)
-		return nil
+	api.Get("/excluded", func(c *fiber.Ctx) error {
+		return c.JSON(s.Excluded())
+	})
+	api.Get("/unresolved", func(c *fiber.Ctx) error {
+		return c.JSON(s.Unresolved())
 	})
-	ln, err := net.Listen("tcp", ":8080") //nolint:gosec
+	api.Get("/config", func(c *fiber.Ctx) error {
+		return c.JSON(config.Get().redacted())
+	})
+	api.Get("/metrics", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(metrics.JSON())
+	})
+	registerMappingRoutes(api, mappings)
+	registerRoomRoutes(api, config, s)
+	registerManualRoutes(api, config, s)
+	registerPaddingRoutes(api, config, s)
+	registerLifecycleRoutes(api, s)
+	registerEventRoutes(ctx, api, bus)
+	registerWatchRoutes(ctx, api, s)
+	registerHistoryRoutes(api, h)
+	registerWebhookRoutes(api, config)
+	registerScheduleRoutes(api, config, s)
+	ln, err := net.Listen("tcp", cfg.Address)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Fatal(app.Listener(ln))
+	go func() {
+		if err := app.Listener(ln); err != nil {
+			log.Printf("API server stopped: %v", err)
+		}
+		cancel()
+	}()
+
+	select {
+	case sig := <-signals:
+		log.Printf("Received %v, shutting down", sig)
+	case <-ctx.Done():
+	}
+	shutdown(config.Get(), cancel, app, s, loops)
+	if h != nil {
+		if err := h.Close(); err != nil {
+			log.Printf("Failed to close history: %v", err)
+		}
+	}
+}
+
+// shutdown stops accepting requests, lets all loops and in-flight playout
+// requests finish within cfg.ShutdownTimeout and closes the playout connections.
+func shutdown(cfg *Configuration, cancel context.CancelFunc, app *fiber.App, s *store.Store, loops []chan struct{}) {
+	cancel()
+	if err := app.Shutdown(); err != nil {
+		log.Printf("Failed to shut down API server: %v", err)
+	}
+	deadline := time.After(cfg.ShutdownTimeout)
+	for _, done := range loops {
+		select {
+		case <-done:
+		case <-deadline:
+			log.Println("Shutdown timeout exceeded, not waiting for remaining loops")
+			if err := s.SaveState(); err != nil {
+				log.Printf("Failed to save state: %v", err)
+			}
+			s.Close()
+			return
+		}
+	}
+	if err := s.SaveState(); err != nil {
+		log.Printf("Failed to save state: %v", err)
+	}
+	s.Close()
+	log.Println("Shutdown complete")
 }
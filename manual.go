@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/protobuf/ptypes"
+	"log"
+	"net/url"
+	"time"
+)
+
+// runningJob returns the GUID of the job currently playing in room.
+func runningJob(scheduled map[string]api.ScheduledJob, room string, now time.Time) (string, bool) {
+	for guid, sj := range scheduled {
+		if sj.Room != room {
+			continue
+		}
+		start, err := ptypes.Timestamp(sj.StartAt)
+		if err != nil {
+			continue
+		}
+		stop, err := ptypes.Timestamp(sj.StopAt)
+		if err != nil {
+			continue
+		}
+		if !now.Before(start) && now.Before(stop) {
+			return guid, true
+		}
+	}
+	return "", false
+}
+
+// expireManualActions hands jobs back to the scheduler once they are over, both according
+// to the Fahrplan and on their playout server. Actions on jobs which are gone from both
+// expire after retention.
+func expireManualActions(s *store.Store, now time.Time, retention time.Duration) {
+	snapshot := s.Snapshot()
+	for guid, action := range s.ManualActions() {
+		end := action.At.Add(retention)
+		known := false
+		if job, ok := snapshot.PlayoutJobs[guid]; ok {
+			end, known = job.Start.Add(job.Duration), true
+		}
+		if sj, ok := snapshot.Scheduled[guid]; ok {
+			if stop, err := ptypes.Timestamp(sj.StopAt); err == nil && (!known || stop.After(end)) {
+				end = stop
+			}
+		}
+		if now.Before(end) {
+			continue
+		}
+		if err := s.ClearManualAction(guid); err != nil {
+			log.Printf("Failed to expire manual %s of %s: %v", action.Action, guid, err)
+		}
+	}
+}
+
+// submitManual sends job with the given times to the playout server of its room
+// and keeps the scheduler from overwriting it afterwards.
+func submitManual(cfg *Configuration, s *store.Store, job fahrplan.PlayoutJob, start time.Time, stop time.Time, action string) (*api.ScheduledJob, error) {
+	client, ok := resolvePlayoutClient(cfg, s, job)
+	if !ok {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, "no playout server for room "+job.Room)
+	}
+	pj, err := playoutJob(job, start, stop)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+	}
+	previous := setJobSubmitted(s, job, "manual "+action)
+	scheduledJob, err := submit(client, pj, job.Room)
+	publishResult(s, job, previous, scheduledJob, err)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadGateway, fmt.Sprintf("playout server refused job: %v", err))
+	}
+	log.Printf("Manual %s of %s (talk %d) in %s", action, job.GUID, job.ID, job.Room)
+
+	s.UpdateScheduled(func(scheduled map[string]api.ScheduledJob) {
+		scheduled[job.GUID] = *scheduledJob
+	})
+	job.Start = start
+	job.Duration = stop.Sub(start)
+	s.MarkSubmitted(job, nil)
+	if err := s.SetManualAction(job.GUID, store.ManualAction{Action: action, At: time.Now()}); err != nil {
+		log.Printf("Failed to save state: %v", err)
+	}
+	return scheduledJob, nil
+}
+
+func registerManualRoutes(api fiber.Router, config *configHolder, s *store.Store) {
+	api.Post("/rooms/:room/live", func(c *fiber.Ctx) error {
+		cfg := config.Get()
+		room, err := roomParam(c, cfg)
+		if err != nil {
+			return err
+		}
+		if roomMode(cfg, s, room) == store.ModePaused {
+			return fiber.NewError(fiber.StatusConflict, "room "+room+" is paused")
+		}
+		body := struct {
+			GUID     string `json:"guid"`
+			Duration string `json:"duration"`
+		}{}
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		job, ok := s.PlayoutJobs()[body.GUID]
+		if !ok {
+			return fiber.NewError(fiber.StatusNotFound, "unknown job "+body.GUID)
+		}
+		job.Room = room
+
+		now := time.Now()
+		stop := job.Start.Add(job.Duration)
+		if body.Duration != "" {
+			d, err := time.ParseDuration(body.Duration)
+			if err != nil || d <= 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "duration has to be a positive duration")
+			}
+			stop = now.Add(d)
+		}
+		if !stop.After(now) {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "job is already over, a duration is required")
+		}
+		scheduledJob, err := submitManual(cfg, s, job, now, stop, store.ActionLive)
+		if err != nil {
+			return err
+		}
+		return c.JSON(scheduledJob)
+	})
+	api.Post("/rooms/:room/stop", func(c *fiber.Ctx) error {
+		cfg := config.Get()
+		room, err := roomParam(c, cfg)
+		if err != nil {
+			return err
+		}
+		body := struct {
+			GUID string `json:"guid"`
+		}{}
+		if len(c.Body()) > 0 {
+			if err := json.Unmarshal(c.Body(), &body); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			}
+		}
+
+		now := time.Now()
+		scheduled := s.Scheduled()
+		guid := body.GUID
+		if guid == "" {
+			var ok bool
+			if guid, ok = runningJob(scheduled, room, now); !ok {
+				return fiber.NewError(fiber.StatusNotFound, "nothing is playing in room "+room)
+			}
+		}
+		sj, ok := scheduled[guid]
+		if !ok {
+			return fiber.NewError(fiber.StatusNotFound, "job "+guid+" is not scheduled")
+		}
+		if sj.Room != room {
+			return fiber.NewError(fiber.StatusNotFound, "job "+guid+" is not scheduled in room "+room)
+		}
+		start, err := ptypes.Timestamp(sj.StartAt)
+		if err != nil {
+			return err
+		}
+		stop, err := ptypes.Timestamp(sj.StopAt)
+		if err != nil {
+			return err
+		}
+		if !start.Before(now) {
+			// the playout servers can only schedule jobs, a pending one can't be taken back
+			return fiber.NewError(fiber.StatusConflict, "job "+guid+" did not start yet and the playout server can't cancel scheduled jobs")
+		}
+		if !now.Before(stop) {
+			// resubmitting it would play it again until now
+			return fiber.NewError(fiber.StatusConflict, "job "+guid+" is already over")
+		}
+		job, ok := s.Submitted()[guid]
+		if !ok {
+			job = fahrplan.PlayoutJob{GUID: guid, Source: sj.Source, Version: sj.Version}
+		}
+		job.Room = sj.Room
+		scheduledJob, err := submitManual(cfg, s, job, start, now, store.ActionStop)
+		if err != nil {
+			return err
+		}
+		return c.JSON(scheduledJob)
+	})
+	api.Delete("/jobs/:guid/manual", func(c *fiber.Ctx) error {
+		guid, err := url.PathUnescape(c.Params("guid"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if err := s.ClearManualAction(guid); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+}
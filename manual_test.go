@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/store/storetest"
+	"github.com/gofiber/fiber/v2"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStopRejectsJobs(t *testing.T) {
+	s := storetest.New(t)
+	now := time.Now()
+	s.UpdateScheduled(func(scheduled map[string]api.ScheduledJob) {
+		scheduled["other room"] = storetest.ScheduledJob(t, "Saal 2", now.Add(-time.Minute), now.Add(time.Hour))
+		scheduled["over"] = storetest.ScheduledJob(t, "Saal 1", now.Add(-time.Hour), now.Add(-time.Minute))
+	})
+	app := fiber.New()
+	registerManualRoutes(app, newConfigHolder(&Configuration{}), s)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"job of another room", `{"guid":"other room"}`, fiber.StatusNotFound},
+		{"job is over", `{"guid":"over"}`, fiber.StatusConflict},
+		{"nothing playing", ``, fiber.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/rooms/Saal%201/stop", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.status {
+				t.Errorf("got status %d, want %d", resp.StatusCode, test.status)
+			}
+		})
+	}
+	if len(s.ManualActions()) != 0 {
+		t.Errorf("rejected stops recorded manual actions: %v", s.ManualActions())
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"github.com/Garionion/playout-controller/studio"
+	"github.com/gofiber/fiber/v2"
+	"net/url"
+	"strconv"
+)
+
+func mappingError(err error) error {
+	switch {
+	case errors.Is(err, studio.ErrNotFound):
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	case errors.Is(err, studio.ErrStudioExists), errors.Is(err, studio.ErrStudioInUse), errors.Is(err, studio.ErrInvalidRows), errors.Is(err, studio.ErrNoTalkFile):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, studio.ErrUnknownStudio), errors.Is(err, studio.ErrInvalidStudio):
+		return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+	default:
+		return err
+	}
+}
+
+func talkID(c *fiber.Ctx) (int, error) {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "talk id has to be an integer")
+	}
+	return id, nil
+}
+
+func registerMappingRoutes(api fiber.Router, mappings *studio.Watcher) {
+	api.Get("/mappings/status", func(c *fiber.Ctx) error {
+		loaded, errs := mappings.Errors()
+		messages := make([]string, 0, len(errs))
+		for _, e := range errs {
+			messages = append(messages, e.Error())
+		}
+		return c.JSON(fiber.Map{"loaded": loaded, "errors": messages})
+	})
+	api.Get("/studios", func(c *fiber.Ctx) error {
+		return c.JSON(mappings.Studios())
+	})
+	api.Post("/studios", func(c *fiber.Ctx) error {
+		s := new(studio.Studio)
+		if err := json.Unmarshal(c.Body(), s); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if err := mappings.CreateStudio(*s); err != nil {
+			return mappingError(err)
+		}
+		return c.Status(fiber.StatusCreated).JSON(s)
+	})
+	api.Put("/studios/:name", func(c *fiber.Ctx) error {
+		name, err := url.PathUnescape(c.Params("name"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		s := new(studio.Studio)
+		if err := json.Unmarshal(c.Body(), s); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if err := mappings.PutStudio(name, *s); err != nil {
+			return mappingError(err)
+		}
+		s.Name = name
+		return c.JSON(s)
+	})
+	api.Delete("/studios/:name", func(c *fiber.Ctx) error {
+		name, err := url.PathUnescape(c.Params("name"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if err := mappings.DeleteStudio(name); err != nil {
+			return mappingError(err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	api.Get("/talks/:id/studio", func(c *fiber.Ctx) error {
+		id, err := talkID(c)
+		if err != nil {
+			return err
+		}
+		s, err := mappings.TalkStudio(id)
+		if err != nil && !errors.Is(err, studio.ErrUnknownStudio) {
+			return mappingError(err)
+		}
+		return c.JSON(s)
+	})
+	api.Put("/talks/:id/studio", func(c *fiber.Ctx) error {
+		id, err := talkID(c)
+		if err != nil {
+			return err
+		}
+		body := struct {
+			Studio string `json:"studio"`
+		}{}
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if err := mappings.AssignTalk(id, body.Studio); err != nil {
+			return mappingError(err)
+		}
+		s, _ := mappings.TalkStudio(id)
+		return c.JSON(s)
+	})
+	api.Delete("/talks/:id/studio", func(c *fiber.Ctx) error {
+		id, err := talkID(c)
+		if err != nil {
+			return err
+		}
+		if err := mappings.UnassignTalk(id); err != nil {
+			return mappingError(err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+)
+
+var (
+	ScheduledJobs     = expvar.NewInt("scheduled_jobs")
+	FailedJobs        = expvar.NewInt("failed_jobs")
+	ResubmittedJobs   = expvar.NewInt("resubmitted_jobs")
+	UnresolvedRooms   = expvar.NewMap("unresolved_rooms")
+	DroppedJobs       = expvar.NewMap("dropped_jobs")
+	PublishedEvents   = expvar.NewMap("published_events")
+	DroppedEvents     = expvar.NewMap("dropped_events")
+	WebhookDeliveries = expvar.NewMap("webhook_deliveries")
+)
+
+// JSON renders all published variables the same way expvar's HTTP handler does.
+func JSON() []byte {
+	var b bytes.Buffer
+	b.WriteString("{")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !first {
+			b.WriteString(",")
+		}
+		first = false
+		fmt.Fprintf(&b, "%q:%s", kv.Key, kv.Value)
+	})
+	b.WriteString("}")
+	return b.Bytes()
+}
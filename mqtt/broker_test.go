@@ -0,0 +1,148 @@
+package mqtt
+
+import (
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// broker is a minimal in-process MQTT broker for tests. It supports QoS 0 and 1,
+// retained messages and subscriptions with a trailing # wildcard.
+type broker struct {
+	listener net.Listener
+	mu       sync.Mutex
+	retained map[string][]byte
+	subs     map[net.Conn][]string
+	wg       sync.WaitGroup
+}
+
+func newBroker(t *testing.T) *broker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &broker{listener: l, retained: map[string][]byte{}, subs: map[net.Conn][]string{}}
+	b.wg.Add(1)
+	go b.accept()
+	t.Cleanup(b.close)
+	return b
+}
+
+func (b *broker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func (b *broker) close() {
+	b.listener.Close()
+	b.mu.Lock()
+	for conn := range b.subs {
+		conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+func (b *broker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.subs[conn] = nil
+		b.mu.Unlock()
+		b.wg.Add(1)
+		go b.serve(conn)
+	}
+}
+
+func matches(filter string, topic string) bool {
+	if strings.HasSuffix(filter, "#") {
+		return strings.HasPrefix(topic, strings.TrimSuffix(filter, "#"))
+	}
+	return filter == topic
+}
+
+func (b *broker) write(conn net.Conn, p packets.ControlPacket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_ = p.Write(conn)
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer b.wg.Done()
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		p, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := p.(type) {
+		case *packets.ConnectPacket:
+			b.write(conn, packets.NewControlPacket(packets.Connack))
+		case *packets.PingreqPacket:
+			b.write(conn, packets.NewControlPacket(packets.Pingresp))
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = make([]byte, len(p.Topics))
+			b.mu.Lock()
+			b.subs[conn] = append(b.subs[conn], p.Topics...)
+			_ = ack.Write(conn)
+			for topic, payload := range b.retained {
+				for _, filter := range p.Topics {
+					if matches(filter, topic) {
+						_ = publishPacket(topic, payload, true).Write(conn)
+						break
+					}
+				}
+			}
+			b.mu.Unlock()
+		case *packets.PublishPacket:
+			if p.Qos > 0 {
+				ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				ack.MessageID = p.MessageID
+				b.write(conn, ack)
+			}
+			b.publish(p.TopicName, p.Payload, p.Retain)
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func publishPacket(topic string, payload []byte, retain bool) *packets.PublishPacket {
+	m := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	m.TopicName = topic
+	m.Payload = payload
+	m.Retain = retain
+	return m
+}
+
+func (b *broker) publish(topic string, payload []byte, retain bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if retain {
+		if len(payload) == 0 {
+			delete(b.retained, topic)
+		} else {
+			b.retained[topic] = payload
+		}
+	}
+	for conn, filters := range b.subs {
+		for _, filter := range filters {
+			if matches(filter, topic) {
+				_ = publishPacket(topic, payload, false).Write(conn)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"github.com/Garionion/playout-controller/events"
+	"github.com/Garionion/playout-controller/store"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/protobuf/ptypes"
+	jsoniter "github.com/json-iterator/go"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const publishTimeout = 5 * time.Second
+
+// Config describes the broker room and job state is published to. An empty Broker disables MQTT.
+type Config struct {
+	Broker      string        `yaml:"Broker"`
+	ClientID    string        `yaml:"ClientID" env-default:"playout-controller"`
+	Username    string        `yaml:"Username,omitempty"`
+	Password    string        `yaml:"Password,omitempty"`
+	TopicPrefix string        `yaml:"TopicPrefix" env-default:"playout"`
+	QoS         byte          `yaml:"QoS"`
+	Interval    time.Duration `yaml:"Interval" env-default:"5s"`
+}
+
+func (c Config) Validate() error {
+	if c.Broker == "" {
+		return nil
+	}
+	if u, err := url.Parse(c.Broker); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("Broker %q is not a valid URL like tcp://localhost:1883", c.Broker)
+	}
+	if c.QoS > 2 {
+		return fmt.Errorf("QoS has to be 0, 1 or 2, got %d", c.QoS)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("Interval has to be positive, got %v", c.Interval)
+	}
+	if strings.ContainsAny(c.TopicPrefix, "+#") {
+		return fmt.Errorf("TopicPrefix must not contain wildcards")
+	}
+	return nil
+}
+
+// job is the payload describing a job on the room and event topics.
+type job struct {
+	GUID  string    `json:"guid"`
+	ID    int       `json:"id"`
+	Title string    `json:"title"`
+	Start time.Time `json:"start"`
+	Stop  time.Time `json:"stop"`
+}
+
+type event struct {
+	Room  string    `json:"room"`
+	Time  time.Time `json:"time"`
+	Job   *job      `json:"job,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+type roomState struct {
+	current *job
+	next    *job
+}
+
+type roomJob struct {
+	room string
+	job  job
+}
+
+// rooms returns the running and the next scheduled job of every room at now.
+func rooms(snapshot store.Snapshot, now time.Time) map[string]roomState {
+	var jobs []roomJob
+	for guid, sj := range snapshot.Scheduled {
+		start, err := ptypes.Timestamp(sj.StartAt)
+		if err != nil {
+			continue
+		}
+		stop, err := ptypes.Timestamp(sj.StopAt)
+		if err != nil {
+			continue
+		}
+		pj := snapshot.PlayoutJobs[guid]
+		jobs = append(jobs, roomJob{sj.Room, job{GUID: guid, ID: pj.ID, Title: pj.Title, Start: start, Stop: stop}})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].job.Start.Before(jobs[j].job.Start) })
+
+	states := map[string]roomState{}
+	for _, j := range jobs {
+		j := j
+		state := states[j.room]
+		switch {
+		case !now.Before(j.job.Start) && now.Before(j.job.Stop):
+			state.current = &j.job
+		case j.job.Start.After(now) && state.next == nil:
+			state.next = &j.job
+		}
+		states[j.room] = state
+	}
+	return states
+}
+
+// topicName replaces the characters with a special meaning in MQTT topics.
+func topicName(name string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(name)
+}
+
+type publisher struct {
+	cfg      Config
+	client   paho.Client
+	retained map[string]string
+	current  map[string]*job
+	pending  []pendingPublish
+}
+
+type pendingPublish struct {
+	topic    string
+	retained bool
+	token    paho.Token
+}
+
+func (p *publisher) topic(parts ...string) string {
+	return strings.Join(append([]string{p.cfg.TopicPrefix}, parts...), "/")
+}
+
+// publish hands v to the client without waiting for the broker, so a slow broker can't
+// hold up the loop. The outcome is checked by collect.
+func (p *publisher) publish(topic string, retained bool, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("MQTT: %s: %v", topic, err)
+		return
+	}
+	if retained && p.retained[topic] == string(payload) {
+		return
+	}
+	token := p.client.Publish(topic, p.cfg.QoS, retained, payload)
+	if retained {
+		p.retained[topic] = string(payload)
+	}
+	p.pending = append(p.pending, pendingPublish{topic: topic, retained: retained, token: token})
+}
+
+// collect logs the publishes which failed since the last call and forgets their
+// retained payload, so it is sent again.
+func (p *publisher) collect() {
+	pending := p.pending[:0]
+	for _, pp := range p.pending {
+		select {
+		case <-pp.token.Done():
+			if err := pp.token.Error(); err != nil {
+				log.Printf("MQTT: failed to publish %s: %v", pp.topic, err)
+				if pp.retained {
+					delete(p.retained, pp.topic)
+				}
+			}
+		default:
+			pending = append(pending, pp)
+		}
+	}
+	p.pending = pending
+}
+
+// update publishes the retained room topics which changed and the started and ended events.
+// While the broker is unreachable nothing is published, the changes are caught up after
+// the reconnect.
+func (p *publisher) update(s *store.Store, now time.Time) {
+	p.collect()
+	if !p.client.IsConnectionOpen() {
+		return
+	}
+	states := rooms(s.Snapshot(), now)
+	servers := s.ServerStates()
+	// rooms without jobs still get their topics cleared
+	for room := range servers {
+		if _, ok := states[room]; !ok {
+			states[room] = roomState{}
+		}
+	}
+	for room := range p.current {
+		if _, ok := states[room]; !ok {
+			states[room] = roomState{}
+		}
+	}
+	for room, state := range states {
+		name := topicName(room)
+		p.publish(p.topic("rooms", name, "current"), true, state.current)
+		p.publish(p.topic("rooms", name, "next"), true, state.next)
+		if server, ok := servers[room]; ok {
+			p.publish(p.topic("rooms", name, "server"), true, map[string]string{"state": server})
+		}
+
+		previous, known := p.current[room]
+		p.current[room] = state.current
+		if !known || sameJob(previous, state.current) {
+			continue
+		}
+		if previous != nil {
+			p.publish(p.topic("events", "ended"), false, event{Room: room, Time: now, Job: previous})
+		}
+		if state.current != nil {
+			p.publish(p.topic("events", "started"), false, event{Room: room, Time: now, Job: state.current})
+		}
+	}
+}
+
+func sameJob(a *job, b *job) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.GUID == b.GUID
+}
+
+func (p *publisher) result(e events.Event) {
+	if !p.client.IsConnectionOpen() {
+		log.Printf("MQTT: not connected, dropping %s", e.Type)
+		return
+	}
+	ev := event{Time: e.Time, Error: e.Error}
+	if e.Job != nil {
+		ev.Room = e.Job.Room
+		ev.Job = &job{GUID: e.Job.GUID, ID: e.Job.ID, Title: e.Job.Title, Start: e.Job.Start, Stop: e.Job.Start.Add(e.Job.Duration)}
+	}
+	if e.Scheduled != nil && ev.Job != nil {
+		if start, err := ptypes.Timestamp(e.Scheduled.StartAt); err == nil {
+			ev.Job.Start = start
+		}
+		if stop, err := ptypes.Timestamp(e.Scheduled.StopAt); err == nil {
+			ev.Job.Stop = stop
+		}
+	}
+	name := "failed"
+	if e.Type == events.JobScheduled {
+		name = "scheduled"
+	}
+	p.publish(p.topic("events", name), false, ev)
+}
+
+// Run publishes the state of s to the broker until ctx is done. Rooms get the retained topics
+// <prefix>/rooms/<room>/current, next and server, the events are published on
+// <prefix>/events/scheduled, failed, started and ended. <prefix>/status tells whether
+// the controller is online.
+func Run(ctx context.Context, cfg Config, s *store.Store) (chan struct{}, error) {
+	p := &publisher{cfg: cfg, retained: map[string]string{}, current: map[string]*job{}}
+	status := p.topic("status")
+	reconnected := make(chan struct{}, 1)
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(status, "offline", cfg.QoS, true).
+		SetOnConnectHandler(func(c paho.Client) {
+			c.Publish(status, cfg.QoS, true, "online")
+			select {
+			case reconnected <- struct{}{}:
+			default:
+			}
+		}).
+		SetConnectionLostHandler(func(c paho.Client, err error) {
+			log.Printf("MQTT: connection to broker lost: %v", err)
+		})
+	p.client = paho.NewClient(opts)
+	if token := p.client.Connect(); token.WaitTimeout(publishTimeout) && token.Error() != nil {
+		return nil, token.Error()
+	}
+
+	sub := s.Events().Subscribe("mqtt", 64, events.JobScheduled, events.JobFailed)
+	ticker := time.NewTicker(cfg.Interval)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer sub.Close()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.update(s, time.Now())
+			case e := <-sub.Events():
+				p.result(e)
+				p.update(s, time.Now())
+			case <-reconnected:
+				// the broker may have lost the retained messages
+				p.retained = map[string]string{}
+				p.pending = nil
+				p.update(s, time.Now())
+			case <-ctx.Done():
+				if p.client.IsConnectionOpen() {
+					p.client.Publish(status, cfg.QoS, true, "offline").WaitTimeout(publishTimeout)
+				}
+				p.client.Disconnect(250)
+				return
+			}
+		}
+	}()
+	return done, nil
+}
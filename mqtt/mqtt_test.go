@@ -0,0 +1,242 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/events"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/Garionion/playout-controller/store/storetest"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2020, 12, 27, 10, 0, 0, 0, time.UTC)
+
+func at(hour int, minute int) time.Time {
+	return base.Add(time.Duration(hour-10)*time.Hour + time.Duration(minute)*time.Minute)
+}
+
+func TestRooms(t *testing.T) {
+	snapshot := store.Snapshot{
+		PlayoutJobs: map[string]fahrplan.PlayoutJob{"a": {GUID: "a", ID: 1, Title: "Opening"}},
+		Scheduled: map[string]api.ScheduledJob{
+			"a": storetest.ScheduledJob(t, "Saal 1", at(10, 0), at(11, 0)),
+			"b": storetest.ScheduledJob(t, "Saal 1", at(11, 0), at(12, 0)),
+			"c": storetest.ScheduledJob(t, "Saal 1", at(12, 0), at(13, 0)),
+			"d": storetest.ScheduledJob(t, "Saal 2", at(9, 0), at(10, 0)),
+		},
+	}
+	type state struct{ current, next string }
+	tests := []struct {
+		name string
+		now  time.Time
+		want map[string]state
+	}{
+		{"before", at(9, 30), map[string]state{"Saal 1": {"", "a"}, "Saal 2": {"d", ""}}},
+		{"start and stop", at(10, 0), map[string]state{"Saal 1": {"a", "b"}, "Saal 2": {}}},
+		{"running", at(10, 59), map[string]state{"Saal 1": {"a", "b"}, "Saal 2": {}}},
+		{"back to back", at(11, 0), map[string]state{"Saal 1": {"b", "c"}, "Saal 2": {}}},
+		{"last", at(12, 30), map[string]state{"Saal 1": {"c", ""}, "Saal 2": {}}},
+		{"after", at(13, 0), map[string]state{"Saal 1": {}, "Saal 2": {}}},
+	}
+	guid := func(j *job) string {
+		if j == nil {
+			return ""
+		}
+		return j.GUID
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := map[string]state{}
+			for room, s := range rooms(snapshot, test.now) {
+				got[room] = state{guid(s.current), guid(s.next)}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+	current := rooms(snapshot, at(10, 0))["Saal 1"].current
+	if current.ID != 1 || current.Title != "Opening" || !current.Start.Equal(at(10, 0)) || !current.Stop.Equal(at(11, 0)) {
+		t.Errorf("got current job %+v", current)
+	}
+}
+
+type message struct {
+	topic    string
+	retained bool
+	payload  string
+}
+
+type token struct {
+	err error
+}
+
+func (t token) Wait() bool                     { return true }
+func (t token) WaitTimeout(time.Duration) bool { return true }
+func (t token) Error() error                   { return t.err }
+func (t token) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// client records the published messages. fail makes the next publish of a topic fail.
+type client struct {
+	paho.Client
+	connected bool
+	published []message
+	fail      map[string]bool
+}
+
+func (c *client) IsConnectionOpen() bool {
+	return c.connected
+}
+
+func (c *client) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	c.published = append(c.published, message{topic, retained, string(payload.([]byte))})
+	if c.fail[topic] {
+		delete(c.fail, topic)
+		return token{errors.New("broker went away")}
+	}
+	return token{}
+}
+
+// take returns the topics published since the last call.
+func (c *client) take() []string {
+	var topics []string
+	for _, m := range c.published {
+		topics = append(topics, m.topic)
+	}
+	c.published = nil
+	return topics
+}
+
+func TestUpdate(t *testing.T) {
+	s := storetest.New(t)
+	s.UpdateScheduled(func(scheduled map[string]api.ScheduledJob) {
+		scheduled["a"] = storetest.ScheduledJob(t, "Saal 1", at(10, 0), at(11, 0))
+	})
+	c := &client{connected: true, fail: map[string]bool{}}
+	p := &publisher{cfg: Config{TopicPrefix: "playout"}, client: c, retained: map[string]string{}, current: map[string]*job{}}
+
+	steps := []struct {
+		name      string
+		now       time.Time
+		connected bool
+		fail      string
+		want      []string
+	}{
+		{"first update", at(9, 30), true, "", []string{"playout/rooms/Saal 1/current", "playout/rooms/Saal 1/next"}},
+		{"unchanged", at(9, 45), true, "", nil},
+		{"started", at(10, 0), true, "playout/rooms/Saal 1/next", []string{"playout/rooms/Saal 1/current", "playout/rooms/Saal 1/next", "playout/events/started"}},
+		{"failed publish is repeated", at(10, 15), true, "", []string{"playout/rooms/Saal 1/next"}},
+		{"disconnected", at(11, 0), false, "", nil},
+		{"ended after reconnect", at(11, 5), true, "", []string{"playout/rooms/Saal 1/current", "playout/events/ended"}},
+		{"ended only once", at(11, 10), true, "", nil},
+	}
+	for _, step := range steps {
+		c.connected = step.connected
+		if step.fail != "" {
+			c.fail[step.fail] = true
+		}
+		p.update(s, step.now)
+		if got := c.take(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: published %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestUpdateEventPayload(t *testing.T) {
+	s := storetest.New(t)
+	s.UpdateScheduled(func(scheduled map[string]api.ScheduledJob) {
+		scheduled["a"] = storetest.ScheduledJob(t, "Saal 1", at(10, 0), at(11, 0))
+	})
+	c := &client{connected: true}
+	p := &publisher{cfg: Config{TopicPrefix: "playout"}, client: c, retained: map[string]string{}, current: map[string]*job{}}
+	p.update(s, at(9, 0))
+	p.update(s, at(10, 0))
+	var started *message
+	for i, m := range c.published {
+		if m.topic == "playout/events/started" {
+			started = &c.published[i]
+		}
+	}
+	if started == nil {
+		t.Fatalf("no started event in %v", c.published)
+	}
+	want := `{"room":"Saal 1","time":"2020-12-27T10:00:00Z","job":{"guid":"a","id":0,"title":"","start":"2020-12-27T10:00:00Z","stop":"2020-12-27T11:00:00Z"}}`
+	if started.retained || started.payload != want {
+		t.Errorf("got %+v, want %s", *started, want)
+	}
+}
+
+func TestRunWithBroker(t *testing.T) {
+	b := newBroker(t)
+	s := storetest.New(t)
+	now := time.Now()
+	s.SetPlayoutJobs(map[string]fahrplan.PlayoutJob{"a": {GUID: "a", ID: 1, Title: "Opening", Room: "Saal 1"}})
+	s.UpdateScheduled(func(scheduled map[string]api.ScheduledJob) {
+		scheduled["a"] = storetest.ScheduledJob(t, "Saal 1", now.Add(-time.Minute), now.Add(time.Hour))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done, err := Run(ctx, Config{Broker: b.url(), ClientID: "controller", TopicPrefix: "playout", Interval: 20 * time.Millisecond}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan message, 64)
+	receive := func(_ paho.Client, m paho.Message) {
+		received <- message{m.Topic(), m.Retained(), string(m.Payload())}
+	}
+	// retained messages may arrive before the subscription is routed to receive
+	sub := paho.NewClient(paho.NewClientOptions().AddBroker(b.url()).SetClientID("dashboard").SetDefaultPublishHandler(receive))
+	if token := sub.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to connect: %v", token.Error())
+	}
+	defer sub.Disconnect(0)
+	token := sub.Subscribe("playout/#", 0, receive)
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to subscribe: %v", token.Error())
+	}
+
+	// retained messages arrive in any order, so every message is kept
+	var seen []message
+	expect := func(topic string, contains string) {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for i := 0; ; i++ {
+			if i == len(seen) {
+				select {
+				case m := <-received:
+					seen = append(seen, m)
+				case <-timeout:
+					t.Fatalf("did not receive %s containing %q", topic, contains)
+				}
+			}
+			if m := seen[i]; m.topic == topic && strings.Contains(m.payload, contains) {
+				return
+			}
+		}
+	}
+	expect("playout/status", "online")
+	expect("playout/rooms/Saal 1/current", `"title":"Opening"`)
+
+	s.Events().Publish(events.Event{Type: events.JobFailed, Job: &fahrplan.PlayoutJob{GUID: "b", Room: "Saal 2"}, Error: "unavailable"})
+	expect("playout/events/failed", `"error":"unavailable"`)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher did not stop")
+	}
+	expect("playout/status", "offline")
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/protobuf/ptypes"
+	"net/url"
+	"time"
+)
+
+func validatePadding(name string, p store.Padding) error {
+	if p.PrePadding != nil && *p.PrePadding < 0 {
+		return fmt.Errorf("%s: PrePadding must not be negative", name)
+	}
+	if p.MaxPostPadding != nil && *p.MaxPostPadding < 0 {
+		return fmt.Errorf("%s: MaxPostPadding must not be negative", name)
+	}
+	return nil
+}
+
+func validatePaddings(cfg *Configuration) []string {
+	var problems []string
+	for setting, paddings := range map[string]map[string]store.Padding{
+		"RoomPadding":  cfg.RoomPadding,
+		"TrackPadding": cfg.TrackPadding,
+		"TypePadding":  cfg.TypePadding,
+	} {
+		for name, p := range paddings {
+			if err := validatePadding(setting+" "+name, p); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+	return problems
+}
+
+// jobPadding returns the pre-padding and the maximal post-padding of job. The most specific
+// setting wins: the override of the job, TypePadding, TrackPadding, RoomPadding and at last
+// the global PrePadding and MaxPostPadding.
+func jobPadding(cfg *Configuration, s *store.Store, job fahrplan.PlayoutJob) (time.Duration, time.Duration) {
+	pre, post := cfg.PrePadding, cfg.MaxPostPadding
+	apply := func(p store.Padding, ok bool) {
+		if !ok {
+			return
+		}
+		if p.PrePadding != nil {
+			pre = *p.PrePadding
+		}
+		if p.MaxPostPadding != nil {
+			post = *p.MaxPostPadding
+		}
+	}
+	p, ok := cfg.RoomPadding[job.Room]
+	apply(p, ok)
+	p, ok = cfg.TrackPadding[job.Track]
+	apply(p, ok && job.Track != "")
+	p, ok = cfg.TypePadding[job.Type]
+	apply(p, ok && job.Type != "")
+	p, ok = s.JobPadding()[job.GUID]
+	apply(p, ok)
+	return pre, post
+}
+
+// scheduledJob is a job accepted by a playout server together with the padding
+// it was submitted with.
+type scheduledJob struct {
+	*api.ScheduledJob
+	PrePadding  time.Duration `json:"prePadding"`
+	PostPadding time.Duration `json:"postPadding"`
+}
+
+// scheduledJobs returns the scheduled jobs with the padding effectively applied,
+// i.e. the difference between their playout and their Fahrplan times.
+func scheduledJobs(s *store.Store) map[string]scheduledJob {
+	scheduled := s.Scheduled()
+	submitted := s.Submitted()
+	jobs := make(map[string]scheduledJob, len(scheduled))
+	for guid, sj := range scheduled {
+		sj := sj
+		j := scheduledJob{ScheduledJob: &sj}
+		if job, ok := submitted[guid]; ok {
+			if start, err := ptypes.Timestamp(sj.StartAt); err == nil {
+				j.PrePadding = job.Start.Sub(start)
+			}
+			if stop, err := ptypes.Timestamp(sj.StopAt); err == nil {
+				j.PostPadding = stop.Sub(job.Start.Add(job.Duration))
+			}
+		}
+		jobs[guid] = j
+	}
+	return jobs
+}
+
+func registerPaddingRoutes(api fiber.Router, config *configHolder, s *store.Store) {
+	guidParam := func(c *fiber.Ctx) (string, error) {
+		guid, err := url.PathUnescape(c.Params("guid"))
+		if err != nil {
+			return "", fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		return guid, nil
+	}
+	api.Get("/jobs/:guid/padding", func(c *fiber.Ctx) error {
+		guid, err := guidParam(c)
+		if err != nil {
+			return err
+		}
+		override, overridden := s.JobPadding()[guid]
+		job, ok := s.PlayoutJobs()[guid]
+		if !ok && !overridden {
+			return fiber.NewError(fiber.StatusNotFound, "unknown job "+guid)
+		}
+		job.GUID = guid
+		job.Room = config.Get().rooms.Resolve(job.Room)
+		pre, post := jobPadding(config.Get(), s, job)
+		return c.JSON(fiber.Map{"guid": guid, "prePadding": pre, "maxPostPadding": post, "override": override})
+	})
+	// changing the padding of a job does not submit it, the scheduler resubmits
+	// jobs whose padding changed once they are upcoming
+	api.Put("/jobs/:guid/padding", func(c *fiber.Ctx) error {
+		guid, err := guidParam(c)
+		if err != nil {
+			return err
+		}
+		if _, ok := s.PlayoutJobs()[guid]; !ok {
+			if _, overridden := s.JobPadding()[guid]; !overridden {
+				return fiber.NewError(fiber.StatusNotFound, "unknown job "+guid)
+			}
+		}
+		body := struct {
+			PrePadding     string `json:"prePadding"`
+			MaxPostPadding string `json:"maxPostPadding"`
+		}{}
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		parse := func(name string, value string) (*time.Duration, error) {
+			if value == "" {
+				return nil, nil
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return nil, fiber.NewError(fiber.StatusBadRequest, name+" has to be a non-negative duration")
+			}
+			return &d, nil
+		}
+		var p store.Padding
+		if p.PrePadding, err = parse("prePadding", body.PrePadding); err != nil {
+			return err
+		}
+		if p.MaxPostPadding, err = parse("maxPostPadding", body.MaxPostPadding); err != nil {
+			return err
+		}
+		if p.PrePadding == nil && p.MaxPostPadding == nil {
+			return fiber.NewError(fiber.StatusBadRequest, "prePadding or maxPostPadding is required")
+		}
+		if err := s.SetJobPadding(guid, p); err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"guid": guid, "override": p})
+	})
+	api.Delete("/jobs/:guid/padding", func(c *fiber.Ctx) error {
+		guid, err := guidParam(c)
+		if err != nil {
+			return err
+		}
+		if err := s.ClearJobPadding(guid); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+}
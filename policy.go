@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/events"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/metrics"
+	"github.com/Garionion/playout-controller/store"
+	"log"
+)
+
+const (
+	PolicyStrict   = "strict"
+	PolicyFallback = "fallback"
+	PolicyDrop     = "drop"
+)
+
+// RoomPolicy decides what happens to jobs of a room without a playout server.
+type RoomPolicy struct {
+	Policy   string `yaml:"Policy"`
+	Fallback string `yaml:"Fallback,omitempty"`
+}
+
+func (p RoomPolicy) validate(servers map[string]string) error {
+	switch p.Policy {
+	case PolicyStrict, PolicyDrop:
+		return nil
+	case PolicyFallback:
+		if _, ok := servers[p.Fallback]; !ok {
+			return fmt.Errorf("fallback room %q has no playout server", p.Fallback)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown policy %q", p.Policy)
+	}
+}
+
+func validateRoomPolicies(cfg *Configuration) error {
+	if cfg.UnknownRoomPolicy.Policy == "" {
+		if _, ok := cfg.PlayoutServers[""]; ok {
+			log.Println(`PlayoutServers entry "" is deprecated, configure UnknownRoomPolicy instead`)
+			cfg.UnknownRoomPolicy = RoomPolicy{Policy: PolicyFallback, Fallback: ""}
+		} else {
+			cfg.UnknownRoomPolicy.Policy = PolicyStrict
+		}
+	}
+	if err := cfg.UnknownRoomPolicy.validate(cfg.PlayoutServers); err != nil {
+		return fmt.Errorf("UnknownRoomPolicy: %w", err)
+	}
+	for room, p := range cfg.RoomPolicies {
+		if err := p.validate(cfg.PlayoutServers); err != nil {
+			return fmt.Errorf("RoomPolicies %s: %w", room, err)
+		}
+	}
+	return nil
+}
+
+func roomPolicy(cfg *Configuration, room string) RoomPolicy {
+	if p, ok := cfg.RoomPolicies[room]; ok {
+		return p
+	}
+	return cfg.UnknownRoomPolicy
+}
+
+// resolvePlayoutClient returns the client job has to be sent to according to the room policies.
+func resolvePlayoutClient(cfg *Configuration, s *store.Store, job fahrplan.PlayoutJob) (api.PlayoutClient, bool) {
+	if client, ok := s.PlayoutClient(job.Room); ok {
+		return client, true
+	}
+	policy := roomPolicy(cfg, job.Room)
+	switch policy.Policy {
+	case PolicyFallback:
+		if client, ok := s.PlayoutClient(policy.Fallback); ok {
+			log.Printf("server for Room %s not found, using fallback Room %s\n", job.Room, policy.Fallback)
+			unresolvedRoom(s, job, policy)
+			return client, true
+		}
+		log.Printf("server for Room %s not found, neither for fallback Room %s\n", job.Room, policy.Fallback)
+	case PolicyDrop:
+		metrics.DroppedJobs.Add(job.Room, 1)
+		return nil, false
+	default:
+		log.Printf("server for Room %s not found\n", job.Room)
+	}
+	unresolvedRoom(s, job, policy)
+	return nil, false
+}
+
+// unresolvedRoom records that job found no playout server and announces rooms seen for the first time.
+// Retries of the same job are neither counted nor recorded again.
+func unresolvedRoom(s *store.Store, job fahrplan.PlayoutJob, policy RoomPolicy) {
+	newRoom, newJob := s.AddUnresolvedRoom(job.Room, job.GUID, policy.Policy)
+	if newJob {
+		metrics.UnresolvedRooms.Add(job.Room, 1)
+	}
+	if newRoom {
+		s.Events().Publish(events.Event{Type: events.RoomUnresolved, Room: job.Room, Job: &job})
+	}
+}
+
+// pruneUnresolvedRooms forgets the unresolved rooms which got a playout server or whose
+// jobs are dropped according to cfg, and updates the policy of the remaining ones.
+func pruneUnresolvedRooms(cfg *Configuration, s *store.Store) {
+	servers := s.ServerStates()
+	s.PruneUnresolvedRooms(func(u store.UnresolvedRoom) (store.UnresolvedRoom, bool) {
+		if _, ok := servers[u.Room]; ok {
+			return u, false
+		}
+		u.Policy = roomPolicy(cfg, u.Room).Policy
+		return u, u.Policy != PolicyDrop
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/store/storetest"
+	"testing"
+)
+
+func TestPruneUnresolvedRooms(t *testing.T) {
+	s := storetest.New(t)
+	strict := RoomPolicy{Policy: PolicyStrict}
+	for _, room := range []string{"Saal 1", "Saal 2", "Saal 3"} {
+		unresolvedRoom(s, fahrplan.PlayoutJob{GUID: room, Room: room}, strict)
+	}
+	cfg := &Configuration{
+		UnknownRoomPolicy: strict,
+		RoomPolicies: map[string]RoomPolicy{
+			"Saal 2": {Policy: PolicyDrop},
+			"Saal 3": {Policy: PolicyFallback, Fallback: "Saal 1"},
+		},
+	}
+
+	pruneUnresolvedRooms(cfg, s)
+
+	unresolved := s.Unresolved()
+	for room, want := range map[string]string{"Saal 1": PolicyStrict, "Saal 3": PolicyFallback} {
+		if u, ok := unresolved[room]; !ok || u.Policy != want {
+			t.Errorf("%s: got %+v, want policy %s", room, u, want)
+		}
+	}
+	if _, ok := unresolved["Saal 2"]; ok {
+		t.Error("dropped room is still unresolved")
+	}
+}
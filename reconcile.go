@@ -0,0 +1,132 @@
+package main
+
+import (
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/metrics"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/golang/protobuf/ptypes"
+	"log"
+	"time"
+)
+
+// serverRoom returns the room whose playout server receives the jobs of room.
+func serverRoom(cfg *Configuration, s *store.Store, room string) string {
+	if _, ok := s.PlayoutClient(room); ok {
+		return room
+	}
+	if p := roomPolicy(cfg, room); p.Policy == PolicyFallback {
+		return p.Fallback
+	}
+	return ""
+}
+
+// sameScheduled reports whether a and b are the same answer of a playout server.
+func sameScheduled(a api.ScheduledJob, b api.ScheduledJob) bool {
+	return a.ID == b.ID && a.Version == b.Version && a.Source == b.Source && a.Room == b.Room &&
+		a.StartAt.GetSeconds() == b.StartAt.GetSeconds() && a.StartAt.GetNanos() == b.StartAt.GetNanos() &&
+		a.StopAt.GetSeconds() == b.StopAt.GetSeconds() && a.StopAt.GetNanos() == b.StopAt.GetNanos()
+}
+
+// resubmit sends the jobs played out by the server of room again, exactly as the server
+// accepted them before, and returns how many it accepted. After a reconnect these are all
+// jobs which did not end yet, otherwise only the ones which did not start yet, as sending a
+// running job again may restart its playout. A reconciliation only records the jobs whose
+// state changes, so it does not add transitions and events for every job on every tick.
+// Jobs with a manual action and jobs which moved to another server are left alone, as are
+// jobs whose scheduled entry changed while resubmitting.
+func resubmit(cfg *Configuration, s *store.Store, room string, now time.Time, reconnected bool) int {
+	client, ok := s.PlayoutClient(room)
+	if !ok {
+		return 0
+	}
+	reason := "reconciliation"
+	if reconnected {
+		reason = "playout server reconnected"
+	}
+	submitted := s.Submitted()
+	playoutJobs := s.PlayoutJobs()
+	manual := s.ManualActions()
+	lifecycles := s.Lifecycles()
+	scheduled := s.Scheduled()
+	resubmitted := map[string]api.ScheduledJob{}
+	for guid, sj := range scheduled {
+		stop, err := ptypes.Timestamp(sj.StopAt)
+		if err != nil || !stop.After(now) || serverRoom(cfg, s, sj.Room) != room {
+			continue
+		}
+		if start, err := ptypes.Timestamp(sj.StartAt); err != nil || (!reconnected && !start.After(now)) {
+			continue
+		}
+		if roomMode(cfg, s, sj.Room) == store.ModePaused {
+			continue
+		}
+		if _, ok := manual[guid]; ok {
+			continue
+		}
+		if job, ok := playoutJobs[guid]; ok && serverRoom(cfg, s, cfg.rooms.Resolve(job.Room)) != room {
+			continue
+		}
+		job, ok := submitted[guid]
+		if !ok {
+			job = fahrplan.PlayoutJob{GUID: guid, Room: sj.Room, Version: sj.Version, Source: sj.Source}
+		}
+		pj := &api.Job{StartAt: sj.StartAt, StopAt: sj.StopAt, Source: sj.Source, ID: sj.ID, Version: sj.Version}
+		previous := lifecycles[guid].State
+		record := reconnected || previous != store.StateAccepted
+		if record {
+			previous = setJobSubmitted(s, job, reason)
+		}
+		scheduledJob, err := submit(client, pj, sj.Room)
+		if err != nil && !record {
+			previous = setJobSubmitted(s, job, reason)
+		}
+		if record || err != nil {
+			publishResult(s, job, previous, scheduledJob, err)
+		}
+		if err != nil {
+			log.Printf("Failed to resubmit %s to room %s: %v", guid, room, err)
+			continue
+		}
+		metrics.ResubmittedJobs.Add(1)
+		resubmitted[guid] = *scheduledJob
+	}
+	s.UpdateScheduled(func(current map[string]api.ScheduledJob) {
+		for guid, sj := range resubmitted {
+			if old, ok := current[guid]; ok && sameScheduled(old, scheduled[guid]) {
+				current[guid] = sj
+			}
+		}
+	})
+	return len(resubmitted)
+}
+
+// resubmitReconnected resubmits the jobs of every playout server whose epoch differs
+// from epochs and returns the current epochs. An epoch starts whenever the connection to
+// a server becomes ready again, so this reacts to reconnects, not to actual restarts:
+// after a network blip the jobs are sent again as well, which only replaces them with
+// identical ones on the server. Servers which restart without the connection breaking
+// are only caught by reconcile.
+func resubmitReconnected(cfg *Configuration, s *store.Store, epochs map[string]uint64) map[string]uint64 {
+	current := s.ServerEpochs()
+	for room, epoch := range current {
+		if epoch != epochs[room] {
+			n := resubmit(cfg, s, room, time.Now(), true)
+			log.Printf("Resubmitted %d jobs to the playout server of room %s", n, room)
+		}
+	}
+	return current
+}
+
+// reconcile resubmits the pending jobs of every connected playout server. The playout
+// servers can't list their jobs, so this is the only way a server which lost them without
+// the connection breaking, e.g. because it restarted quickly, gets them back. A server
+// which still knows a job replaces it with the identical one.
+func reconcile(cfg *Configuration, s *store.Store) {
+	now := time.Now()
+	for room, state := range s.ServerStates() {
+		if state == "READY" {
+			resubmit(cfg, s, room, now, false)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"github.com/Garionion/playout-controller/store"
+	"github.com/gofiber/fiber/v2"
+	"net/url"
+	"sort"
+	"time"
+)
+
+type roomInfo struct {
+	Name           string            `json:"name"`
+	Mode           store.RoomMode    `json:"mode"`
+	ModeOverridden bool              `json:"modeOverridden"`
+	PlayoutServer  bool              `json:"playoutServer"`
+	ServerState    string            `json:"serverState,omitempty"`
+	ServerEpoch    uint64            `json:"serverEpoch"`
+	Jobs           int               `json:"jobs"`
+	Offset         *store.RoomOffset `json:"offset,omitempty"`
+}
+
+// roomMode returns the scheduling mode of room, rooms without an explicit
+// mode follow the global AutoSchedule setting.
+func roomMode(cfg *Configuration, s *store.Store, room string) store.RoomMode {
+	if mode, ok := s.RoomMode(room); ok {
+		return mode
+	}
+	if cfg.AutoSchedule {
+		return store.ModeAuto
+	}
+	return store.ModeManual
+}
+
+func autoScheduled(cfg *Configuration, s *store.Store, room string) bool {
+	return roomMode(cfg, s, room) == store.ModeAuto
+}
+
+func listRooms(cfg *Configuration, s *store.Store) []roomInfo {
+	rooms := map[string]*roomInfo{}
+	room := func(name string) *roomInfo {
+		if r, ok := rooms[name]; ok {
+			return r
+		}
+		r := &roomInfo{Name: name}
+		rooms[name] = r
+		return r
+	}
+	for name := range cfg.PlayoutServers {
+		room(name).PlayoutServer = true
+	}
+	epochs := s.ServerEpochs()
+	for name, state := range s.ServerStates() {
+		room(name).ServerState = state
+		room(name).ServerEpoch = epochs[name]
+	}
+	jobs := s.PlayoutJobs()
+	for _, job := range jobs {
+		room(job.Room).Jobs++
+	}
+	for name := range s.RoomModes() {
+		room(name)
+	}
+	for name, offset := range s.RoomOffsets() {
+		offset := offset
+		room(name).Offset = &offset
+	}
+
+	list := make([]roomInfo, 0, len(rooms))
+	for name, r := range rooms {
+		_, r.ModeOverridden = s.RoomMode(name)
+		r.Mode = roomMode(cfg, s, name)
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// roomParam returns the canonical name of the room in the request path.
+func roomParam(c *fiber.Ctx, cfg *Configuration) (string, error) {
+	room, err := url.PathUnescape(c.Params("room"))
+	if err != nil {
+		return "", fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return cfg.rooms.Resolve(room), nil
+}
+
+func registerRoomRoutes(api fiber.Router, config *configHolder, s *store.Store) {
+	api.Get("/rooms", func(c *fiber.Ctx) error {
+		return c.JSON(listRooms(config.Get(), s))
+	})
+	api.Get("/rooms/:room/timeline", func(c *fiber.Ctx) error {
+		room, err := roomParam(c, config.Get())
+		if err != nil {
+			return err
+		}
+		return c.JSON(roomTimeline(s, room))
+	})
+	api.Put("/rooms/:room/mode", func(c *fiber.Ctx) error {
+		cfg := config.Get()
+		room, err := roomParam(c, cfg)
+		if err != nil {
+			return err
+		}
+		body := struct {
+			Mode string `json:"mode"`
+		}{}
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		mode, err := store.ParseRoomMode(body.Mode)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if err := s.SetRoomMode(room, mode); err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"room": room, "mode": roomMode(cfg, s, room)})
+	})
+	api.Put("/rooms/:room/offset", func(c *fiber.Ctx) error {
+		room, err := roomParam(c, config.Get())
+		if err != nil {
+			return err
+		}
+		body := struct {
+			From   time.Time `json:"from"`
+			Offset string    `json:"offset"`
+		}{}
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		offset, err := time.ParseDuration(body.Offset)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid offset: "+err.Error())
+		}
+		if body.From.IsZero() {
+			body.From = time.Now()
+		}
+		o := store.RoomOffset{From: body.From, Offset: offset}
+		if err := s.SetRoomOffset(room, o); err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"room": room, "offset": o})
+	})
+	api.Delete("/rooms/:room/offset", func(c *fiber.Ctx) error {
+		room, err := roomParam(c, config.Get())
+		if err != nil {
+			return err
+		}
+		if err := s.ClearRoomOffset(room); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	api.Delete("/rooms/:room/mode", func(c *fiber.Ctx) error {
+		cfg := config.Get()
+		room, err := roomParam(c, cfg)
+		if err != nil {
+			return err
+		}
+		if err := s.ClearRoomMode(room); err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"room": room, "mode": roomMode(cfg, s, room)})
+	})
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/events"
 	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/metrics"
 	"github.com/Garionion/playout-controller/store"
 	"github.com/golang/protobuf/ptypes"
-	"github.com/grafov/bcast"
 	jsoniter "github.com/json-iterator/go"
 	"log"
 	"time"
@@ -14,92 +16,268 @@ import (
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
-//nolint:funlen
-func schedule(cfg *Configuration, store *store.Store, jobs map[int]fahrplan.PlayoutJob, scheduledJobs map[int]api.ScheduledJob, addPadding bool) map[int]api.ScheduledJob {
-	servers := store.GrpcClients
-	defaultRoom, defRoomExist := servers[""]
+// scheduleOptions control how the playout times of a job are derived from its Fahrplan times.
+type scheduleOptions struct {
+	// Padding adds pre- and post-padding to the job.
+	Padding bool
+	// PrePadding and PostPadding replace the padding configured for the job.
+	PrePadding  *time.Duration
+	PostPadding *time.Duration
+	// RespectNext keeps the post-padding from reaching into the next talk of the room.
+	RespectNext bool
+}
+
+// autoSchedule are the options of jobs submitted by the scheduler.
+var autoSchedule = scheduleOptions{Padding: true, RespectNext: true}
+
+// jobTimes returns when job has to start and stop on the playout server.
+func jobTimes(cfg *Configuration, s *store.Store, job fahrplan.PlayoutJob, opts scheduleOptions) (time.Time, time.Time) {
+	start := job.Start
+	stop := job.Start.Add(job.Duration)
+	if !opts.Padding {
+		return start, stop
+	}
+	prePadding, postPadding := jobPadding(cfg, s, job)
+	if opts.PrePadding != nil {
+		prePadding = *opts.PrePadding
+	}
+	if opts.PostPadding != nil {
+		postPadding = *opts.PostPadding
+	}
+	if opts.RespectNext && !job.Next.IsZero() && postPadding > job.Next.Sub(stop) {
+		postPadding = job.Next.Sub(stop)
+	}
+	return start.Add(-prePadding), stop.Add(postPadding)
+}
+
+func playoutJob(job fahrplan.PlayoutJob, start time.Time, stop time.Time) (*api.Job, error) {
+	startAt, err := ptypes.TimestampProto(start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert Start-Timestamp: %w", err)
+	}
+	stopAt, err := ptypes.TimestampProto(stop)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert Stop-Timestamp: %w", err)
+	}
+	return &api.Job{
+		StartAt: startAt,
+		StopAt:  stopAt,
+		Source:  job.Source,
+		ID:      job.PlayoutID(),
+		Version: job.Version,
+	}, nil
+}
+
+// submit sends job to client. The request gets its own timeout, so it is not
+// interrupted by a shutdown.
+func submit(client api.PlayoutClient, job *api.Job, room string) (*api.ScheduledJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	scheduledJob, err := client.SchedulePlayout(ctx, job)
+	if err != nil {
+		metrics.FailedJobs.Add(1)
+		return nil, err
+	}
+	metrics.ScheduledJobs.Add(1)
+	scheduledJob.Room = room
+	return scheduledJob, nil
+}
+
+// scheduleJob submits job to its playout server and records the outcome in the store,
+// except for the scheduled jobs which the caller has to update.
+func scheduleJob(cfg *Configuration, s *store.Store, job fahrplan.PlayoutJob, opts scheduleOptions) (*api.ScheduledJob, error) {
+	playoutClient, ok := resolvePlayoutClient(cfg, s, job)
+	if !ok {
+		return nil, fmt.Errorf("no playout server for room %s", job.Room)
+	}
+	start, stop := jobTimes(cfg, s, job, opts)
+	pj, err := playoutJob(job, start, stop)
+	if err != nil {
+		return nil, err
+	}
+	var padding *store.Padding
+	if opts.Padding && opts.PrePadding == nil && opts.PostPadding == nil {
+		pre, post := jobPadding(cfg, s, job)
+		padding = &store.Padding{PrePadding: &pre, MaxPostPadding: &post}
+	}
+	previous := setJobSubmitted(s, job, "")
+	scheduledJob, err := submit(playoutClient, pj, job.Room)
+	publishResult(s, job, previous, scheduledJob, err)
+	if err != nil {
+		return nil, err
+	}
+	s.MarkSubmitted(job, padding)
+	return scheduledJob, nil
+}
+
+// schedule submits jobs to their playout servers, adds them to the scheduled jobs
+// of the store and returns them. Once ctx is done no further jobs are submitted,
+// requests already in flight are allowed to finish.
+func schedule(ctx context.Context, cfg *Configuration, store *store.Store, jobs map[string]fahrplan.PlayoutJob, opts scheduleOptions) map[string]api.ScheduledJob {
+	scheduledJobs := make(map[string]api.ScheduledJob, len(jobs))
+	pruneUnresolvedRooms(cfg, store)
 	for _, job := range jobs {
-		playoutClient, ok := servers[job.Room]
-		if !ok {
-			if defRoomExist {
-				log.Printf("server for Room %s not found, using default Room\n", job.Room)
-				playoutClient = defaultRoom
-			} else {
-				log.Printf("server for Room %s not found\n", job.Room)
-				continue
-			}
-		}
-		var postPadding time.Duration
-		if !job.Next.IsZero() && cfg.MaxPostPadding > job.Next.Sub(job.Start.Add(job.Duration)) {
-			postPadding = job.Next.Sub(job.Start.Add(job.Duration))
-		} else {
-			postPadding = cfg.MaxPostPadding
-		}
-		jobStop := job.Start.Add(job.Duration)
-		if addPadding {
-			job.Start = job.Start.Add(-cfg.PrePadding)
-			jobStop = jobStop.Add(postPadding)
-		}
-		start, err := ptypes.TimestampProto(job.Start)
-		if err != nil {
-			log.Printf("%d: Failed to convert Start-Timestamp: %v", job.ID, err)
+		if ctx.Err() != nil {
+			log.Printf("Not scheduling %s (talk %d), shutting down", job.GUID, job.ID)
+			continue
 		}
-		stop, err := ptypes.TimestampProto(jobStop)
+		job.Room = cfg.rooms.Resolve(job.Room)
+		scheduledJob, err := scheduleJob(cfg, store, job, opts)
 		if err != nil {
-			log.Printf("%d: Failed to convert Stop-Timestamp: %v", job.ID, err)
+			log.Printf("Failed to schedule %s (talk %d): %v", job.GUID, job.ID, err)
+			continue
 		}
-		playoutJob := &api.Job{
-			StartAt: start,
-			StopAt:  stop,
-			Source:  job.Source,
-			ID:      int64(job.ID),
-			Version: job.Version,
+		log.Printf("Scheduled %s (talk %d)", job.GUID, job.ID)
+		scheduledJobs[job.GUID] = *scheduledJob
+	}
+	if len(scheduledJobs) > 0 {
+		store.UpdateScheduled(func(scheduled map[string]api.ScheduledJob) {
+			for id, job := range scheduledJobs {
+				scheduled[id] = job
+			}
+		})
+	}
+	return scheduledJobs
+}
+
+// publishResult records and announces the outcome of submitting job, which was in state
+// previous before. Failed jobs are retried on every tick, so JobFailed is only published
+// when a job newly failed.
+func publishResult(s *store.Store, job fahrplan.PlayoutJob, previous store.JobState, scheduledJob *api.ScheduledJob, err error) {
+	if err != nil {
+		setJobState(s, job, store.StateFailed, err.Error())
+		if previous != store.StateFailed {
+			s.Events().Publish(events.Event{Type: events.JobFailed, Job: &job, Error: err.Error()})
 		}
+		return
+	}
+	setJobState(s, job, store.StateAccepted, "")
+	s.Events().Publish(events.Event{Type: events.JobScheduled, Job: &job, Scheduled: scheduledJob})
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// changed reports whether job differs from the version sent to the playout server.
+func changed(job fahrplan.PlayoutJob, submitted fahrplan.PlayoutJob) bool {
+	return job.Version != submitted.Version || job.Source != submitted.Source ||
+		!job.Start.Equal(submitted.Start) || job.Duration != submitted.Duration
+}
 
-		scheduledJob, err := playoutClient.SchedulePlayout(ctx, playoutJob)
-		if err != nil {
-			log.Printf("Failed to schedule %d: %v", job.ID, err)
-			cancel()
+// paddingChanged reports whether the configured padding of job differs from the padding
+// it was submitted with, e.g. after a config reload. Jobs submitted with explicitly chosen
+// padding keep it.
+func paddingChanged(cfg *Configuration, s *store.Store, job fahrplan.PlayoutJob, submitted map[string]store.Padding) bool {
+	p, ok := submitted[job.GUID]
+	if !ok || p.PrePadding == nil || p.MaxPostPadding == nil {
+		return false
+	}
+	job.Room = cfg.rooms.Resolve(job.Room)
+	pre, post := jobPadding(cfg, s, job)
+	return pre != *p.PrePadding || post != *p.MaxPostPadding
+}
+
+func removeAlreadyScheduledJobs(cfg *Configuration, s *store.Store, jobs map[string]fahrplan.PlayoutJob) map[string]fahrplan.PlayoutJob {
+	submitted := s.Submitted()
+	paddings := s.SubmittedPadding()
+	toSchedule := make(map[string]fahrplan.PlayoutJob, len(jobs))
+	for id, job := range jobs {
+		if old, ok := submitted[id]; ok && !changed(job, old) && !paddingChanged(cfg, s, job, paddings) {
 			continue
 		}
-
-		log.Printf("Scheduled %v", job.ID)
-		scheduledJob.Room = job.Room
-		scheduledJobs[job.ID] = *scheduledJob
-		cancel()
+		toSchedule[id] = job
 	}
-	return scheduledJobs
+	return toSchedule
 }
 
-func removeAlreadyScheduledJobs(jobs map[int]fahrplan.PlayoutJob, scheduled map[int]api.ScheduledJob) map[int]fahrplan.PlayoutJob {
-	for id := range scheduled {
-		if jobs[id].Version != scheduled[id].Version {
+// rescheduleChanged resubmits jobs which were already sent to a playout server
+// but have been changed since, e.g. because their room got delayed.
+func rescheduleChanged(ctx context.Context, cfg *Configuration, s *store.Store, jobs map[string]fahrplan.PlayoutJob) {
+	submitted := s.Submitted()
+	paddings := s.SubmittedPadding()
+	manual := s.ManualActions()
+	now := time.Now()
+	toSchedule := make(map[string]fahrplan.PlayoutJob)
+	for id, job := range jobs {
+		old, ok := submitted[id]
+		if !ok || job.Start.Add(job.Duration).Before(now) {
+			continue
+		}
+		if !changed(job, old) && !paddingChanged(cfg, s, job, paddings) {
+			continue
+		}
+		if _, ok := manual[id]; ok {
+			continue
+		}
+		if roomMode(cfg, s, job.Room) == store.ModePaused {
 			continue
 		}
-		delete(jobs, id)
+		toSchedule[id] = job
 	}
-	return jobs
+	if len(toSchedule) == 0 {
+		return
+	}
+	log.Printf("Rescheduling %d changed jobs", len(toSchedule))
+	schedule(ctx, cfg, s, toSchedule, autoSchedule)
 }
 
-func scheduler(cfg *Configuration, store *store.Store, upcomingChannel *bcast.Member, scheduledChannel *bcast.Member) chan struct{} {
-	quit := make(chan struct{})
-	go func(cfg *Configuration, upcomingChannel *bcast.Member, scheduledChannel *bcast.Member) {
-		scheduled := make(map[int]api.ScheduledJob)
-		for upcoming := range upcomingChannel.Read {
-			u := upcoming.(map[int]fahrplan.PlayoutJob)
-			if !cfg.AutoSchedule {
-				continue
-			}
-			toSchedule := removeAlreadyScheduledJobs(u, scheduled)
-			if len(toSchedule) == 0 {
-				log.Println("Nothing new to Schedule")
-			} else {
-				scheduled = schedule(cfg, store, toSchedule, scheduled, true)
-				scheduledChannel.Send(scheduled)
+// scheduleUpcoming submits the upcoming jobs which were not sent to their playout server yet.
+func scheduleUpcoming(ctx context.Context, cfg *Configuration, s *store.Store, upcoming map[string]fahrplan.PlayoutJob) {
+	toSchedule := removeAlreadyScheduledJobs(cfg, s, upcoming)
+	manual := s.ManualActions()
+	for id, job := range toSchedule {
+		if _, ok := manual[id]; ok || !autoScheduled(cfg, s, job.Room) {
+			delete(toSchedule, id)
+		}
+	}
+	if len(toSchedule) == 0 {
+		log.Println("Nothing new to Schedule")
+	} else {
+		schedule(ctx, cfg, s, toSchedule, autoSchedule)
+	}
+}
+
+// scheduler submits the upcoming jobs, resubmits jobs changed by a Fahrplan update,
+// resubmits the jobs of playout servers which reconnected and periodically reconciles
+// the pending jobs of all servers. All automatic submissions happen on its goroutine,
+// so they never race with each other.
+// Changed jobs are taken from a store watcher, so they are only compared when the jobs
+// actually changed. The upcoming jobs come from every tick instead, which retries failed
+// submissions.
+func scheduler(ctx context.Context, config *configHolder, s *store.Store) chan struct{} {
+	sub := s.Events().Subscribe("scheduler", 8, events.UpcomingChanged)
+	_, watcher := s.WatchSnapshot(64)
+	epochs := s.ServerEpochs()
+	interval := config.Get().ReconcileInterval
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+		defer sub.Close()
+		defer func() { watcher.Close() }()
+		for {
+			select {
+			case e := <-sub.Events():
+				scheduleUpcoming(ctx, config.Get(), s, e.Jobs)
+			case c, ok := <-watcher.Changes():
+				if !ok {
+					log.Println("Scheduler lagged behind the store, comparing all jobs")
+					var snapshot store.Snapshot
+					snapshot, watcher = s.WatchSnapshot(64)
+					rescheduleChanged(ctx, config.Get(), s, snapshot.PlayoutJobs)
+					continue
+				}
+				if c.Collection == store.CollectionPlayoutJobs {
+					rescheduleChanged(ctx, config.Get(), s, s.PlayoutJobs())
+				}
+			case <-s.ServersReconnected():
+				epochs = resubmitReconnected(config.Get(), s, epochs)
+			case <-ticker.C:
+				cfg := config.Get()
+				resetTicker(ticker, &interval, cfg.ReconcileInterval)
+				reconcile(cfg, s)
+			case <-ctx.Done():
+				return
 			}
 		}
-	}(cfg, upcomingChannel, scheduledChannel)
-	return quit
+	}()
+	return done
 }
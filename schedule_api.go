@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/gofiber/fiber/v2"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// requestOptions are the scheduling options of a request.
+type requestOptions struct {
+	// Padding defaults to on. PrePadding and PostPadding require it.
+	Padding     *bool  `json:"padding"`
+	PrePadding  string `json:"prePadding"`
+	PostPadding string `json:"postPadding"`
+	// RespectNext defaults to true.
+	RespectNext *bool `json:"respectNext"`
+}
+
+// scheduleRequest is a job submitted by hand. Without options it gets the same padding
+// as the jobs submitted by the scheduler.
+type scheduleRequest struct {
+	fahrplan.PlayoutJob
+	Options requestOptions `json:"options"`
+}
+
+// fieldError tells which part of a request is invalid and why.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type problemFunc func(field string, format string, a ...interface{})
+
+// options returns the scheduling options described by o, starting from defaults.
+func (o requestOptions) options(defaults scheduleOptions, problem problemFunc) scheduleOptions {
+	opts := defaults
+	if o.RespectNext != nil {
+		opts.RespectNext = *o.RespectNext
+	}
+	padding := func(field string, value string) *time.Duration {
+		if value == "" {
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			problem(field, "%s has to be a non-negative duration like 5m", field)
+			return nil
+		}
+		if o.Padding != nil && !*o.Padding {
+			problem(field, "%s can't be used with padding disabled", field)
+		}
+		return &d
+	}
+	opts.PrePadding = padding("options.prePadding", o.PrePadding)
+	opts.PostPadding = padding("options.postPadding", o.PostPadding)
+	switch {
+	case o.Padding != nil:
+		opts.Padding = *o.Padding
+	case opts.PrePadding != nil || opts.PostPadding != nil:
+		opts.Padding = true
+	}
+	return opts
+}
+
+// job validates r and returns the job with its canonical room and the options to schedule it with.
+func (r scheduleRequest) job(cfg *Configuration, s *store.Store) (fahrplan.PlayoutJob, scheduleOptions, []fieldError) {
+	var problems []fieldError
+	problem := func(field string, format string, a ...interface{}) {
+		problems = append(problems, fieldError{Field: field, Message: fmt.Sprintf(format, a...)})
+	}
+
+	job := r.PlayoutJob
+	// jobs are identified on the playout server by their GUID, so every job needs its own
+	if job.GUID == "" {
+		if job.ID <= 0 {
+			problem("guid", "guid or a positive id is required")
+		} else {
+			job.GUID = fahrplan.FallbackGUID(job.ID)
+		}
+	}
+	if job.Start.IsZero() {
+		problem("start", "start is required")
+	}
+	if job.Duration <= 0 {
+		problem("duration", "duration has to be positive, got %v", job.Duration)
+	}
+	if job.Room == "" {
+		problem("room", "room is required")
+	} else {
+		job.Room = cfg.rooms.Resolve(job.Room)
+		if serverRoom(cfg, s, job.Room) == "" {
+			problem("room", "room %q has no playout server", job.Room)
+		}
+	}
+	if job.Source == "" {
+		problem("source", "source is required")
+	} else if u, err := url.Parse(job.Source); err != nil || u.Scheme == "" {
+		problem("source", "source %q is not a valid URL", job.Source)
+	}
+	opts := r.Options.options(autoSchedule, problem)
+	return job, opts, problems
+}
+
+// invalidRequest answers with all problems found in a request.
+func invalidRequest(c *fiber.Ctx, problems []fieldError) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request", "problems": problems})
+}
+
+// jobSelector selects Fahrplan jobs by room, start time and day. All given criteria have to match.
+type jobSelector struct {
+	Room string `json:"room"`
+	// From and To limit the start of the jobs to [From, To).
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Day is the index of the Fahrplan day.
+	Day *int `json:"day"`
+	// Upcoming only selects the jobs the scheduler would submit next.
+	Upcoming bool `json:"upcoming"`
+}
+
+func (sel jobSelector) validate(problem problemFunc) {
+	if sel.Room == "" && sel.From.IsZero() && sel.To.IsZero() && sel.Day == nil && !sel.Upcoming {
+		problem("selector", "selector needs at least one of room, from, to, day or upcoming")
+	}
+	if !sel.From.IsZero() && !sel.To.IsZero() && !sel.To.After(sel.From) {
+		problem("selector.to", "to has to be after from")
+	}
+}
+
+func (sel jobSelector) jobs(cfg *Configuration, s *store.Store) []fahrplan.PlayoutJob {
+	candidates := s.PlayoutJobs()
+	if sel.Upcoming {
+		candidates = s.Upcoming()
+	}
+	room := cfg.rooms.Resolve(sel.Room)
+	var jobs []fahrplan.PlayoutJob
+	for _, job := range candidates {
+		switch {
+		case sel.Room != "" && job.Room != room:
+		case !sel.From.IsZero() && job.Start.Before(sel.From):
+		case !sel.To.IsZero() && !job.Start.Before(sel.To):
+		case sel.Day != nil && job.Day != *sel.Day:
+		default:
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].Start.Equal(jobs[j].Start) {
+			return jobs[i].Start.Before(jobs[j].Start)
+		}
+		return jobs[i].GUID < jobs[j].GUID
+	})
+	return jobs
+}
+
+// bulkRequest either lists the jobs to schedule or selects them from the Fahrplan.
+// Options apply to the selected jobs, which are padded like the scheduler does by default.
+// Like the scheduler, the selector skips jobs with a manual action.
+type bulkRequest struct {
+	Jobs     []scheduleRequest `json:"jobs"`
+	Selector *jobSelector      `json:"selector"`
+	Options  requestOptions    `json:"options"`
+}
+
+const (
+	bulkScheduled = "scheduled"
+	bulkFailed    = "failed"
+	bulkSkipped   = "skipped"
+	bulkInvalid   = "invalid"
+)
+
+type bulkResult struct {
+	GUID      string        `json:"guid"`
+	Room      string        `json:"room,omitempty"`
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Problems  []fieldError  `json:"problems,omitempty"`
+	Scheduled *scheduledJob `json:"scheduled,omitempty"`
+}
+
+type bulkJob struct {
+	job  fahrplan.PlayoutJob
+	opts scheduleOptions
+	// result is the index of the job in the report
+	result int
+}
+
+// scheduleBulk submits jobs concurrently, at most BulkParallelism at a time to every
+// playout server, and fills in their results.
+func scheduleBulk(ctx context.Context, cfg *Configuration, s *store.Store, jobs []bulkJob, results []bulkResult) {
+	servers := map[string][]bulkJob{}
+	for _, j := range jobs {
+		server := serverRoom(cfg, s, j.job.Room)
+		servers[server] = append(servers[server], j)
+	}
+	var mu sync.Mutex
+	scheduled := map[string]api.ScheduledJob{}
+	var wg sync.WaitGroup
+	for _, queue := range servers {
+		next := make(chan bulkJob, len(queue))
+		for _, j := range queue {
+			next <- j
+		}
+		close(next)
+		workers := cfg.BulkParallelism
+		if workers > len(queue) {
+			workers = len(queue)
+		}
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range next {
+					if ctx.Err() != nil {
+						mu.Lock()
+						results[j.result].Status = bulkSkipped
+						results[j.result].Error = "shutting down"
+						mu.Unlock()
+						continue
+					}
+					sj, err := scheduleJob(cfg, s, j.job, j.opts)
+					mu.Lock()
+					if err != nil {
+						results[j.result].Status = bulkFailed
+						results[j.result].Error = err.Error()
+					} else {
+						results[j.result].Status = bulkScheduled
+						scheduled[j.job.GUID] = *sj
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	if len(scheduled) > 0 {
+		s.UpdateScheduled(func(all map[string]api.ScheduledJob) {
+			for id, job := range scheduled {
+				all[id] = job
+			}
+		})
+	}
+	report := scheduledJobs(s)
+	for i := range results {
+		if results[i].Status != bulkScheduled {
+			continue
+		}
+		if sj, ok := report[results[i].GUID]; ok {
+			results[i].Scheduled = &sj
+		}
+	}
+}
+
+// bulkJobs validates req and returns the jobs to submit together with the report
+// already containing the invalid and skipped ones.
+func bulkJobs(cfg *Configuration, s *store.Store, req bulkRequest) ([]bulkJob, []bulkResult, []fieldError) {
+	var problems []fieldError
+	problem := func(field string, format string, a ...interface{}) {
+		problems = append(problems, fieldError{Field: field, Message: fmt.Sprintf(format, a...)})
+	}
+	switch {
+	case len(req.Jobs) > 0 && req.Selector != nil:
+		problem("selector", "jobs and selector can't be combined")
+	case len(req.Jobs) == 0 && req.Selector == nil:
+		problem("jobs", "jobs or selector is required")
+	case req.Selector != nil:
+		req.Selector.validate(problem)
+	}
+	selected := req.Options.options(autoSchedule, problem)
+	if len(problems) > 0 {
+		return nil, nil, problems
+	}
+
+	var jobs []bulkJob
+	var results []bulkResult
+	add := func(job fahrplan.PlayoutJob, opts scheduleOptions, status string, reason string) {
+		if status == "" {
+			jobs = append(jobs, bulkJob{job: job, opts: opts, result: len(results)})
+		}
+		results = append(results, bulkResult{GUID: job.GUID, Room: job.Room, Status: status, Error: reason})
+	}
+	if req.Selector == nil {
+		seen := map[string]bool{}
+		for _, r := range req.Jobs {
+			job, opts, jobProblems := r.job(cfg, s)
+			switch {
+			case len(jobProblems) > 0:
+				results = append(results, bulkResult{GUID: job.GUID, Room: job.Room, Status: bulkInvalid, Problems: jobProblems})
+			case seen[job.GUID]:
+				add(job, opts, bulkInvalid, "job is listed more than once")
+			case roomMode(cfg, s, job.Room) == store.ModePaused:
+				add(job, opts, bulkSkipped, "room is paused")
+			default:
+				add(job, opts, "", "")
+			}
+			seen[job.GUID] = true
+		}
+		return jobs, results, nil
+	}
+
+	now := time.Now()
+	submitted := s.Submitted()
+	paddings := s.SubmittedPadding()
+	manual := s.ManualActions()
+	for _, job := range req.Selector.jobs(cfg, s) {
+		old, isSubmitted := submitted[job.GUID]
+		_, isManual := manual[job.GUID]
+		switch {
+		case !job.Start.Add(job.Duration).After(now):
+			add(job, selected, bulkSkipped, "job is already over")
+		case isManual:
+			add(job, selected, bulkSkipped, "manual override")
+		case roomMode(cfg, s, job.Room) == store.ModePaused:
+			add(job, selected, bulkSkipped, "room is paused")
+		case isSubmitted && !changed(job, old) && !paddingChanged(cfg, s, job, paddings):
+			add(job, selected, bulkSkipped, "already scheduled")
+		default:
+			add(job, selected, "", "")
+		}
+	}
+	return jobs, results, nil
+}
+
+func registerScheduleRoutes(api fiber.Router, config *configHolder, s *store.Store) {
+	api.Post("/schedulePlayout", func(c *fiber.Ctx) error {
+		var req scheduleRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return invalidRequest(c, []fieldError{{Field: "body", Message: err.Error()}})
+		}
+		cfg := config.Get()
+		job, opts, problems := req.job(cfg, s)
+		if len(problems) > 0 {
+			return invalidRequest(c, problems)
+		}
+		if roomMode(cfg, s, job.Room) == store.ModePaused {
+			return fiber.NewError(fiber.StatusConflict, "room "+job.Room+" is paused")
+		}
+		scheduled := schedule(c.Context(), cfg, s, map[string]fahrplan.PlayoutJob{job.GUID: job}, opts)
+		if _, ok := scheduled[job.GUID]; !ok {
+			msg := "job " + job.GUID + " was not scheduled"
+			if l, ok := s.Lifecycles()[job.GUID]; ok && l.State == store.StateFailed {
+				msg += ": " + l.Error
+			}
+			return fiber.NewError(fiber.StatusBadGateway, msg)
+		}
+		return c.JSON(scheduledJobs(s))
+	})
+	api.Post("/schedule/bulk", func(c *fiber.Ctx) error {
+		var req bulkRequest
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return invalidRequest(c, []fieldError{{Field: "body", Message: err.Error()}})
+		}
+		cfg := config.Get()
+		jobs, results, problems := bulkJobs(cfg, s, req)
+		if len(problems) > 0 {
+			return invalidRequest(c, problems)
+		}
+		scheduleBulk(c.Context(), cfg, s, jobs, results)
+		counts := map[string]int{bulkScheduled: 0, bulkFailed: 0, bulkSkipped: 0, bulkInvalid: 0}
+		for _, r := range results {
+			counts[r.Status]++
+		}
+		return c.JSON(fiber.Map{"counts": counts, "results": results})
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/Garionion/playout-controller/store/storetest"
+	"testing"
+	"time"
+)
+
+func TestScheduleRequestJob(t *testing.T) {
+	s := storetest.New(t)
+	cfg := &Configuration{UnknownRoomPolicy: RoomPolicy{Policy: PolicyFallback, Fallback: "Saal 1"}}
+	valid := fahrplan.PlayoutJob{Room: "Saal 1", Start: time.Now().Add(time.Hour), Duration: time.Hour, Source: "rtmp://ingest/a"}
+	disabled := false
+	tests := []struct {
+		name    string
+		guid    string
+		id      int
+		options requestOptions
+		problem string
+		guidOut string
+	}{
+		{"guid", "a", 0, requestOptions{}, "", "a"},
+		{"id only", "", 7, requestOptions{}, "", fahrplan.FallbackGUID(7)},
+		{"neither guid nor id", "", 0, requestOptions{}, "guid", ""},
+		{"negative id", "", -1, requestOptions{}, "guid", ""},
+		{"padding disabled with prePadding", "a", 0, requestOptions{Padding: &disabled, PrePadding: "5m"}, "options.prePadding", "a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := scheduleRequest{PlayoutJob: valid, Options: test.options}
+			r.GUID, r.ID = test.guid, test.id
+			job, _, problems := r.job(cfg, s)
+			switch {
+			case test.problem == "" && len(problems) != 0:
+				t.Errorf("got problems %v", problems)
+			case test.problem != "" && (len(problems) != 1 || problems[0].Field != test.problem):
+				t.Errorf("got problems %v, want one for %s", problems, test.problem)
+			case test.problem == "" && job.GUID != test.guidOut:
+				t.Errorf("got guid %q, want %q", job.GUID, test.guidOut)
+			}
+		})
+	}
+
+	r := scheduleRequest{PlayoutJob: valid}
+	r.GUID = "a"
+	if _, opts, _ := r.job(cfg, s); opts != autoSchedule {
+		t.Errorf("got options %+v without options in the request, want %+v", opts, autoSchedule)
+	}
+}
+
+func TestBulkSelectorSkipsManualOverrides(t *testing.T) {
+	s := storetest.New(t)
+	cfg := &Configuration{}
+	now := time.Now()
+	s.SetPlayoutJobs(map[string]fahrplan.PlayoutJob{
+		"live": {GUID: "live", Room: "Saal 1", Start: now.Add(time.Hour), Duration: time.Hour},
+		"next": {GUID: "next", Room: "Saal 1", Start: now.Add(2 * time.Hour), Duration: time.Hour},
+		"over": {GUID: "over", Room: "Saal 1", Start: now.Add(-2 * time.Hour), Duration: time.Hour},
+	})
+	if err := s.SetManualAction("live", store.ManualAction{Action: store.ActionLive, At: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, results, problems := bulkJobs(cfg, s, bulkRequest{Selector: &jobSelector{Room: "Saal 1"}})
+	if len(problems) > 0 {
+		t.Fatalf("got problems %v", problems)
+	}
+	if len(jobs) != 1 || jobs[0].job.GUID != "next" {
+		t.Errorf("got jobs %v, want only next", jobs)
+	}
+	for _, r := range results {
+		if r.GUID == "live" && (r.Status != bulkSkipped || r.Error != "manual override") {
+			t.Errorf("got %+v for the job with a manual action", r)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"github.com/Garionion/playout-controller/events"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/Garionion/playout-controller/store/storetest"
+	"testing"
+	"time"
+)
+
+func TestPublishResultAnnouncesFailuresOnce(t *testing.T) {
+	s := storetest.New(t)
+	sub := s.Events().Subscribe("test", 8, events.JobFailed)
+	defer sub.Close()
+	job := fahrplan.PlayoutJob{GUID: "a", ID: 1, Room: "Saal 1"}
+	unavailable := errors.New("unavailable")
+
+	for i := 0; i < 3; i++ {
+		previous := setJobSubmitted(s, job, "")
+		publishResult(s, job, previous, nil, unavailable)
+	}
+	if n := len(sub.Events()); n != 1 {
+		t.Fatalf("got %d JobFailed events for repeated failures, want 1", n)
+	}
+	<-sub.Events()
+
+	previous := setJobSubmitted(s, job, "")
+	publishResult(s, job, previous, nil, nil)
+	previous = setJobSubmitted(s, job, "")
+	publishResult(s, job, previous, nil, unavailable)
+	if n := len(sub.Events()); n != 1 {
+		t.Errorf("got %d JobFailed events after the job was accepted in between, want 1", n)
+	}
+	if state := s.Lifecycles()["a"].State; state != store.StateFailed {
+		t.Errorf("got state %s, want %s", state, store.StateFailed)
+	}
+}
+
+func TestPaddingChanged(t *testing.T) {
+	s := storetest.New(t)
+	cfg := &Configuration{PrePadding: time.Minute, MaxPostPadding: 5 * time.Minute}
+	job := fahrplan.PlayoutJob{GUID: "a", Room: "Saal 1", Start: time.Now().Add(time.Hour), Duration: time.Hour, Version: "1"}
+	other := fahrplan.PlayoutJob{GUID: "b", Room: "Saal 1", Start: time.Now().Add(time.Hour), Duration: time.Hour, Version: "1"}
+	pre, post := jobPadding(cfg, s, job)
+	s.MarkSubmitted(job, &store.Padding{PrePadding: &pre, MaxPostPadding: &post})
+	s.MarkSubmitted(other, nil)
+	jobs := map[string]fahrplan.PlayoutJob{"a": job, "b": other}
+
+	if toSchedule := removeAlreadyScheduledJobs(cfg, s, jobs); len(toSchedule) != 0 {
+		t.Errorf("unchanged jobs are scheduled again: %v", toSchedule)
+	}
+
+	cfg.RoomPadding = map[string]store.Padding{"Saal 1": {PrePadding: &post}}
+	toSchedule := removeAlreadyScheduledJobs(cfg, s, jobs)
+	if _, ok := toSchedule["a"]; !ok || len(toSchedule) != 1 {
+		t.Errorf("got %v after the room padding changed, want only a", toSchedule)
+	}
+
+	cfg.RoomPadding = nil
+	if err := s.SetJobPadding("a", store.Padding{MaxPostPadding: &pre}); err != nil {
+		t.Fatal(err)
+	}
+	if !paddingChanged(cfg, s, job, s.SubmittedPadding()) {
+		t.Error("a padding override of the job does not count as change")
+	}
+}
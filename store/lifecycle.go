@@ -0,0 +1,131 @@
+package store
+
+import (
+	"fmt"
+	"github.com/Garionion/playout-controller/events"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"time"
+)
+
+type JobState string
+
+const (
+	// StatePlanned jobs are upcoming but were not sent to a playout server yet.
+	StatePlanned JobState = "planned"
+	// StateSubmitted jobs are being sent to their playout server.
+	StateSubmitted JobState = "submitted"
+	// StateAccepted jobs were acknowledged by their playout server.
+	StateAccepted JobState = "accepted"
+	// StateDue and StatePast jobs were accepted and their playout time started or is over.
+	// The playout servers don't report whether they actually play a job, so these states
+	// only follow from the clock and the connection to the server.
+	StateDue       JobState = "due"
+	StatePast      JobState = "past"
+	StateFailed    JobState = "failed"
+	StateCancelled JobState = "cancelled"
+)
+
+// transitions lists the states a job may move to from each state. Every state may
+// be submitted again, e.g. after a retry, a delay or a manual action.
+var transitions = map[JobState][]JobState{
+	"":             {StatePlanned, StateSubmitted},
+	StatePlanned:   {StateSubmitted, StateCancelled},
+	StateSubmitted: {StateAccepted, StateFailed},
+	StateAccepted:  {StateSubmitted, StateDue, StatePast, StateFailed, StateCancelled},
+	StateDue:       {StateSubmitted, StatePast, StateFailed, StateCancelled},
+	StatePast:      {StateSubmitted},
+	StateFailed:    {StateSubmitted, StatePlanned},
+	StateCancelled: {StateSubmitted, StatePlanned},
+}
+
+// Final reports whether nothing happens to a job in state s without outside intervention.
+func (s JobState) Final() bool {
+	return s == StatePast || s == StateFailed || s == StateCancelled
+}
+
+func (s JobState) allows(next JobState) bool {
+	for _, t := range transitions[s] {
+		if t == next {
+			return true
+		}
+	}
+	return false
+}
+
+// maxTransitions is the number of transitions kept per job. A job which keeps failing is
+// resubmitted on every tick, so only the latest transitions are kept.
+const maxTransitions = 20
+
+type Transition struct {
+	From   JobState  `json:"from"`
+	To     JobState  `json:"to"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Lifecycle is the state of a job and how it got there. Transitions holds at most the
+// last maxTransitions changes.
+type Lifecycle struct {
+	GUID        string       `json:"guid"`
+	Room        string       `json:"room"`
+	State       JobState     `json:"state"`
+	Since       time.Time    `json:"since"`
+	Error       string       `json:"error,omitempty"`
+	Transitions []Transition `json:"transitions"`
+}
+
+// SetJobState moves job to state. Transitions the lifecycle does not allow are rejected,
+// setting the current state again does nothing. reason is kept as Error for failed jobs.
+func (s *Store) SetJobState(job fahrplan.PlayoutJob, state JobState, reason string) error {
+	s.mu.Lock()
+	current := s.current.Lifecycles[job.GUID]
+	if current.State == state {
+		s.mu.Unlock()
+		return nil
+	}
+	if !current.State.allows(state) {
+		s.mu.Unlock()
+		return fmt.Errorf("job %s can't go from %q to %q", job.GUID, current.State, state)
+	}
+	now := time.Now()
+	transitions := current.Transitions
+	if len(transitions) >= maxTransitions {
+		transitions = transitions[len(transitions)-maxTransitions+1:]
+	}
+	l := Lifecycle{
+		GUID:        job.GUID,
+		Room:        job.Room,
+		State:       state,
+		Since:       now,
+		Transitions: append(append([]Transition{}, transitions...), Transition{From: current.State, To: state, At: now, Reason: reason}),
+	}
+	if state == StateFailed {
+		l.Error = reason
+	}
+	s.update(CollectionLifecycles, func(next *Snapshot) {
+		lifecycles := make(map[string]Lifecycle, len(next.Lifecycles)+1)
+		for id, lc := range next.Lifecycles {
+			lifecycles[id] = lc
+		}
+		lifecycles[job.GUID] = l
+		next.Lifecycles = lifecycles
+	})
+	s.mu.Unlock()
+	s.bus.Publish(events.Event{Type: events.JobStateChanged, Job: &job, State: string(state), Previous: string(current.State), Error: l.Error})
+	return nil
+}
+
+// PruneLifecycles forgets jobs which reached a final state before before.
+func (s *Store) PruneLifecycles(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(CollectionLifecycles, func(next *Snapshot) {
+		lifecycles := make(map[string]Lifecycle, len(next.Lifecycles))
+		for id, lc := range next.Lifecycles {
+			if !lc.State.Final() || lc.Since.After(before) {
+				lifecycles[id] = lc
+			}
+		}
+		next.Lifecycles = lifecycles
+	})
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"github.com/Garionion/playout-controller/fahrplan"
+	"testing"
+	"time"
+)
+
+var states = []JobState{"", StatePlanned, StateSubmitted, StateAccepted, StateDue, StatePast, StateFailed, StateCancelled}
+
+// setState puts job into state without going through the transitions.
+func setState(s *Store, guid string, state JobState, since time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(CollectionLifecycles, func(next *Snapshot) {
+		lifecycles := map[string]Lifecycle{}
+		for id, l := range next.Lifecycles {
+			lifecycles[id] = l
+		}
+		if state == "" {
+			delete(lifecycles, guid)
+		} else {
+			lifecycles[guid] = Lifecycle{GUID: guid, State: state, Since: since}
+		}
+		next.Lifecycles = lifecycles
+	})
+}
+
+func TestTransitions(t *testing.T) {
+	allowed := map[JobState][]JobState{
+		"":             {StatePlanned, StateSubmitted},
+		StatePlanned:   {StateSubmitted, StateCancelled},
+		StateSubmitted: {StateAccepted, StateFailed},
+		StateAccepted:  {StateSubmitted, StateDue, StatePast, StateFailed, StateCancelled},
+		StateDue:       {StateSubmitted, StatePast, StateFailed, StateCancelled},
+		StatePast:      {StateSubmitted},
+		StateFailed:    {StateSubmitted, StatePlanned},
+		StateCancelled: {StateSubmitted, StatePlanned},
+	}
+	s := newTestStore(t)
+	job := fahrplan.PlayoutJob{GUID: "a", Room: "Saal 1"}
+	for _, from := range states {
+		for _, to := range states[1:] {
+			want := from == to
+			for _, a := range allowed[from] {
+				want = want || a == to
+			}
+			setState(s, job.GUID, from, time.Now())
+			err := s.SetJobState(job, to, "test")
+			if (err == nil) != want {
+				t.Errorf("%q -> %q: got error %v, want allowed %v", from, to, err, want)
+			}
+			l := s.Lifecycles()[job.GUID]
+			switch {
+			case err != nil && l.State != from:
+				t.Errorf("%q -> %q: rejected transition changed the state to %q", from, to, l.State)
+			case err == nil && l.State != to:
+				t.Errorf("%q -> %q: got state %q", from, to, l.State)
+			}
+		}
+	}
+}
+
+func TestSetJobState(t *testing.T) {
+	s := newTestStore(t)
+	sub := s.Events().Subscribe("test", 8)
+	defer sub.Close()
+	job := fahrplan.PlayoutJob{GUID: "a", Room: "Saal 1"}
+	for _, state := range []JobState{StatePlanned, StateSubmitted, StateFailed} {
+		if err := s.SetJobState(job, state, "because"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rev := s.Revision()
+	if err := s.SetJobState(job, StateFailed, "again"); err != nil || s.Revision() != rev {
+		t.Errorf("setting the same state again: %v, revision %d, want %d", err, s.Revision(), rev)
+	}
+	l := s.Lifecycles()["a"]
+	if l.Error != "because" || l.Room != "Saal 1" || len(l.Transitions) != 3 {
+		t.Errorf("got lifecycle %+v", l)
+	}
+	if last := l.Transitions[2]; last.From != StateSubmitted || last.To != StateFailed || last.Reason != "because" {
+		t.Errorf("got last transition %+v", last)
+	}
+	if n := len(sub.Events()); n != 3 {
+		t.Errorf("got %d events, want 3", n)
+	}
+}
+
+func TestTransitionsAreCapped(t *testing.T) {
+	s := newTestStore(t)
+	job := fahrplan.PlayoutJob{GUID: "a"}
+	for i := 0; i < 100; i++ {
+		_ = s.SetJobState(job, StateSubmitted, "retry")
+		_ = s.SetJobState(job, StateFailed, "unavailable")
+	}
+	l := s.Lifecycles()["a"]
+	if len(l.Transitions) != maxTransitions {
+		t.Fatalf("got %d transitions, want %d", len(l.Transitions), maxTransitions)
+	}
+	if last := l.Transitions[maxTransitions-1]; last.From != StateSubmitted || last.To != StateFailed {
+		t.Errorf("did not keep the latest transition, got %+v", last)
+	}
+}
+
+func TestPruneLifecycles(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Now()
+	setState(s, "old finished", StatePast, now.Add(-2*time.Hour))
+	setState(s, "old failed", StateFailed, now.Add(-2*time.Hour))
+	setState(s, "old accepted", StateAccepted, now.Add(-2*time.Hour))
+	setState(s, "new finished", StatePast, now)
+	s.PruneLifecycles(now.Add(-time.Hour))
+	lifecycles := s.Lifecycles()
+	for guid, want := range map[string]bool{"old finished": false, "old failed": false, "old accepted": true, "new finished": true} {
+		if _, ok := lifecycles[guid]; ok != want {
+			t.Errorf("%s: kept %v, want %v", guid, ok, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"log"
+)
+
+// monitor starts a new epoch for room every time conn becomes ready again, as the
+// playout server may have been restarted and lost its jobs in the meantime. This only
+// sees reconnects: a network blip starts an epoch as well, while a restart which does
+// not break the connection is left to the periodic reconciliation of the scheduler.
+func (s *Store) monitor(room string, conn *grpc.ClientConn) {
+	state := conn.GetState()
+	for state != connectivity.Shutdown && conn.WaitForStateChange(s.ctx, state) {
+		next := conn.GetState()
+		if next == connectivity.Ready && state != connectivity.Ready {
+			log.Printf("Playout server of room %s is ready again", room)
+			s.newEpoch(room, conn)
+		}
+		state = next
+	}
+}
+
+func (s *Store) newEpoch(room string, conn *grpc.ClientConn) {
+	s.mu.Lock()
+	if s.conns[room] != conn {
+		s.mu.Unlock()
+		return
+	}
+	epochs := make(map[string]uint64, len(s.epochs)+1)
+	for r, e := range s.epochs {
+		epochs[r] = e
+	}
+	epochs[room]++
+	s.epochs = epochs
+	s.mu.Unlock()
+	select {
+	case s.reconnected <- struct{}{}:
+	default:
+	}
+}
+
+// ServerEpochs returns how often the connection to the playout server of each room
+// became ready again since the start, not counting the initial connection.
+func (s *Store) ServerEpochs() map[string]uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.epochs
+}
+
+// ServersReconnected receives a value every time a playout server started a new epoch.
+func (s *Store) ServersReconnected() <-chan struct{} {
+	return s.reconnected
+}
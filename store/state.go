@@ -0,0 +1,306 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type RoomMode string
+
+const (
+	// ModeAuto lets the scheduler submit upcoming jobs of the room.
+	ModeAuto RoomMode = "auto"
+	// ModeManual only allows jobs scheduled through the API.
+	ModeManual RoomMode = "manual"
+	// ModePaused does not allow any jobs to be scheduled.
+	ModePaused RoomMode = "paused"
+)
+
+func ParseRoomMode(mode string) (RoomMode, error) {
+	switch m := RoomMode(mode); m {
+	case ModeAuto, ModeManual, ModePaused:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown room mode %q", mode)
+	}
+}
+
+// RoomOffset delays every job of a room starting at or after From.
+type RoomOffset struct {
+	From   time.Time     `json:"from"`
+	Offset time.Duration `json:"offset"`
+}
+
+const (
+	ActionLive = "live"
+	ActionStop = "stop"
+)
+
+// ManualAction records that an operator started or stopped a job by hand.
+// The scheduler leaves such jobs alone.
+type ManualAction struct {
+	Action string    `json:"action"`
+	At     time.Time `json:"at"`
+}
+
+// Padding overrides PrePadding and MaxPostPadding. Unset fields keep the padding
+// of the less specific level.
+type Padding struct {
+	PrePadding     *time.Duration `yaml:"PrePadding,omitempty" json:"prePadding,omitempty"`
+	MaxPostPadding *time.Duration `yaml:"MaxPostPadding,omitempty" json:"maxPostPadding,omitempty"`
+}
+
+// state is the part of the Store which survives a restart.
+type state struct {
+	RoomModes     map[string]RoomMode     `json:"roomModes"`
+	RoomOffsets   map[string]RoomOffset   `json:"roomOffsets"`
+	ManualActions map[string]ManualAction `json:"manualActions"`
+	JobPadding    map[string]Padding      `json:"jobPadding"`
+}
+
+// LoadState restores the persisted state from file and remembers file for SaveState.
+// A missing file is not an error.
+func (s *Store) LoadState(file string) error {
+	s.mu.Lock()
+	s.stateFile = file
+	s.mu.Unlock()
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st := state{}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	s.mu.Lock()
+	if st.RoomModes != nil {
+		s.roomModes = st.RoomModes
+	}
+	if st.RoomOffsets != nil {
+		s.roomOffsets = st.RoomOffsets
+	}
+	if st.ManualActions != nil {
+		s.manualActions = st.ManualActions
+	}
+	if st.JobPadding != nil {
+		s.jobPadding = st.JobPadding
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Store) state() state {
+	return state{RoomModes: s.roomModes, RoomOffsets: s.roomOffsets, ManualActions: s.manualActions, JobPadding: s.jobPadding}
+}
+
+// SaveState writes the persisted state atomically to the file given to LoadState.
+func (s *Store) SaveState() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.RLock()
+	st := s.state()
+	s.mu.RUnlock()
+	return s.writeState(st)
+}
+
+// writeState writes st to the state file, the caller has to hold saveMu.
+func (s *Store) writeState(st state) error {
+	s.mu.RLock()
+	file := s.stateFile
+	s.mu.RUnlock()
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil || file == "" {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// changeState applies change to a copy of the persisted state and saves it. The change
+// only becomes active once it was saved, so a failed save leaves everything as it was.
+// change has to replace the maps it modifies instead of changing them in place.
+func (s *Store) changeState(change func(st *state)) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.RLock()
+	st := s.state()
+	s.mu.RUnlock()
+	change(&st)
+	if err := s.writeState(st); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.roomModes = st.RoomModes
+	s.roomOffsets = st.RoomOffsets
+	s.manualActions = st.ManualActions
+	s.jobPadding = st.JobPadding
+	s.mu.Unlock()
+	return nil
+}
+
+// RoomMode returns the mode set for room, ok is false if it uses the default.
+func (s *Store) RoomMode(room string) (mode RoomMode, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	mode, ok = s.roomModes[room]
+	return mode, ok
+}
+
+func (s *Store) RoomModes() map[string]RoomMode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	modes := make(map[string]RoomMode, len(s.roomModes))
+	for room, mode := range s.roomModes {
+		modes[room] = mode
+	}
+	return modes
+}
+
+func (s *Store) SetRoomMode(room string, mode RoomMode) error {
+	return s.changeState(func(st *state) {
+		modes := make(map[string]RoomMode, len(st.RoomModes)+1)
+		for r, m := range st.RoomModes {
+			modes[r] = m
+		}
+		modes[room] = mode
+		st.RoomModes = modes
+	})
+}
+
+// ClearRoomMode makes room use the default mode again.
+func (s *Store) ClearRoomMode(room string) error {
+	return s.changeState(func(st *state) {
+		modes := make(map[string]RoomMode, len(st.RoomModes))
+		for r, m := range st.RoomModes {
+			if r != room {
+				modes[r] = m
+			}
+		}
+		st.RoomModes = modes
+	})
+}
+
+func (s *Store) RoomOffsets() map[string]RoomOffset {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	offsets := make(map[string]RoomOffset, len(s.roomOffsets))
+	for room, offset := range s.roomOffsets {
+		offsets[room] = offset
+	}
+	return offsets
+}
+
+// SetRoomOffset replaces the offset of room and notifies OffsetsChanged.
+func (s *Store) SetRoomOffset(room string, offset RoomOffset) error {
+	return s.changeRoomOffsets(func(offsets map[string]RoomOffset) {
+		offsets[room] = offset
+	})
+}
+
+func (s *Store) ClearRoomOffset(room string) error {
+	return s.changeRoomOffsets(func(offsets map[string]RoomOffset) {
+		delete(offsets, room)
+	})
+}
+
+func (s *Store) changeRoomOffsets(change func(offsets map[string]RoomOffset)) error {
+	err := s.changeState(func(st *state) {
+		offsets := make(map[string]RoomOffset, len(st.RoomOffsets)+1)
+		for room, offset := range st.RoomOffsets {
+			offsets[room] = offset
+		}
+		change(offsets)
+		st.RoomOffsets = offsets
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case s.offsetsChanged <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// OffsetsChanged receives a value every time a room offset was set or cleared.
+func (s *Store) OffsetsChanged() <-chan struct{} {
+	return s.offsetsChanged
+}
+
+// ManualActions returns the manual actions per job GUID.
+func (s *Store) ManualActions() map[string]ManualAction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.manualActions
+}
+
+func (s *Store) SetManualAction(guid string, action ManualAction) error {
+	return s.changeState(func(st *state) {
+		actions := make(map[string]ManualAction, len(st.ManualActions)+1)
+		for id, a := range st.ManualActions {
+			actions[id] = a
+		}
+		actions[guid] = action
+		st.ManualActions = actions
+	})
+}
+
+// ClearManualAction hands the job back to the scheduler.
+func (s *Store) ClearManualAction(guid string) error {
+	return s.changeState(func(st *state) {
+		actions := make(map[string]ManualAction, len(st.ManualActions))
+		for id, a := range st.ManualActions {
+			if id != guid {
+				actions[id] = a
+			}
+		}
+		st.ManualActions = actions
+	})
+}
+
+// JobPadding returns the padding overrides per job GUID.
+func (s *Store) JobPadding() map[string]Padding {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.jobPadding
+}
+
+func (s *Store) SetJobPadding(guid string, padding Padding) error {
+	return s.changeState(func(st *state) {
+		paddings := make(map[string]Padding, len(st.JobPadding)+1)
+		for id, p := range st.JobPadding {
+			paddings[id] = p
+		}
+		paddings[guid] = padding
+		st.JobPadding = paddings
+	})
+}
+
+func (s *Store) ClearJobPadding(guid string) error {
+	return s.changeState(func(st *state) {
+		paddings := make(map[string]Padding, len(st.JobPadding))
+		for id, p := range st.JobPadding {
+			if id != guid {
+				paddings[id] = p
+			}
+		}
+		st.JobPadding = paddings
+	})
+}
@@ -1,68 +1,411 @@
 package store
 
 import (
+	"context"
+	"fmt"
 	"github.com/Garionion/ffmpeg-playout/api"
-	"github.com/grafov/bcast"
+	"github.com/Garionion/playout-controller/events"
 	"github.com/Garionion/playout-controller/fahrplan"
+	jsoniter "github.com/json-iterator/go"
 	"google.golang.org/grpc"
 	"log"
 	"sync"
+	"time"
 )
 
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+type UnresolvedRoom struct {
+	Room     string    `json:"room"`
+	Policy   string    `json:"policy"`
+	Jobs     []string  `json:"jobs"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// Snapshot is a consistent view of the store at Revision. Its maps are shared
+// between all readers and must not be modified.
+type Snapshot struct {
+	Revision    uint64                           `json:"revision"`
+	PlayoutJobs map[string]fahrplan.PlayoutJob   `json:"playoutJobs"`
+	Upcoming    map[string]fahrplan.PlayoutJob   `json:"upcoming"`
+	Scheduled   map[string]api.ScheduledJob      `json:"scheduled"`
+	Excluded    map[string]fahrplan.ExcludedTalk `json:"excluded"`
+	Unresolved  map[string]UnresolvedRoom        `json:"unresolved"`
+	Lifecycles  map[string]Lifecycle             `json:"lifecycles"`
+}
+
+// Store holds the shared state of the controller. Collections are never modified
+// in place, every change replaces them and increments the revision.
 type Store struct {
-	PlayoutJobs map[int]fahrplan.PlayoutJob
-	Upcoming map[int]fahrplan.PlayoutJob
-	Scheduled map[int]api.ScheduledJob
-	GrpcClients map[string]api.PlayoutClient
-	sync.RWMutex
+	mu               sync.RWMutex
+	current          Snapshot
+	clients          map[string]api.PlayoutClient
+	conns            map[string]*grpc.ClientConn
+	addresses        map[string]string
+	submitted        map[string]fahrplan.PlayoutJob
+	submittedPadding map[string]Padding
+	bus              *events.Bus
+	history          []Change
+	watchers         map[*Watcher]struct{}
+
+	ctx         context.Context
+	epochs      map[string]uint64
+	reconnected chan struct{}
+
+	saveMu         sync.Mutex
+	stateFile      string
+	roomModes      map[string]RoomMode
+	roomOffsets    map[string]RoomOffset
+	manualActions  map[string]ManualAction
+	jobPadding     map[string]Padding
+	offsetsChanged chan struct{}
 }
 
-func NewStore(jobChannel *bcast.Member, upcomingChannel *bcast.Member, scheduleChannel *bcast.Member, playoutServers map[string]string) (*Store, error) {
+// NewStore connects to all playout servers and keeps the store updated from bus until ctx is done.
+// The returned channel is closed once the store stopped listening for updates.
+func NewStore(ctx context.Context, bus *events.Bus, playoutServers map[string]string) (*Store, chan struct{}, error) {
 	store := &Store{
-		PlayoutJobs: map[int]fahrplan.PlayoutJob{},
-		Upcoming: map[int]fahrplan.PlayoutJob{},
-		Scheduled: map[int]api.ScheduledJob{},
-		GrpcClients: map[string]api.PlayoutClient{},
+		current: Snapshot{
+			PlayoutJobs: map[string]fahrplan.PlayoutJob{},
+			Upcoming:    map[string]fahrplan.PlayoutJob{},
+			Scheduled:   map[string]api.ScheduledJob{},
+			Excluded:    map[string]fahrplan.ExcludedTalk{},
+			Unresolved:  map[string]UnresolvedRoom{},
+			Lifecycles:  map[string]Lifecycle{},
+		},
+		clients:          map[string]api.PlayoutClient{},
+		conns:            map[string]*grpc.ClientConn{},
+		addresses:        map[string]string{},
+		submitted:        map[string]fahrplan.PlayoutJob{},
+		submittedPadding: map[string]Padding{},
+		bus:              bus,
+		watchers:         map[*Watcher]struct{}{},
+
+		ctx:         ctx,
+		epochs:      map[string]uint64{},
+		reconnected: make(chan struct{}, 1),
+
+		roomModes:      map[string]RoomMode{},
+		roomOffsets:    map[string]RoomOffset{},
+		manualActions:  map[string]ManualAction{},
+		jobPadding:     map[string]Padding{},
+		offsetsChanged: make(chan struct{}, 1),
 	}
-	go func(jobChannel *bcast.Member, upcomingChannel *bcast.Member, scheduleChannel *bcast.Member) {
-		for  {
+	sub := bus.Subscribe("store", 16, events.FahrplanUpdated, events.UpcomingChanged)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer sub.Close()
+		for {
 			select {
-			case playoutJobs := <-jobChannel.Read:
-				p := playoutJobs.(map[int]fahrplan.PlayoutJob)
-				store.SetPlayoutJobs(p)
-			case upcoming := <-upcomingChannel.Read:
-				u := upcoming.(map[int]fahrplan.PlayoutJob)
-				store.SetUpcomingJobs(u)
-			case scheduled := <-scheduleChannel.Read:
-				s := scheduled.(map[int]api.ScheduledJob)
-				store.SetScheduledJobs(s)
+			case <-ctx.Done():
+				return
+			case e := <-sub.Events():
+				switch e.Type {
+				case events.FahrplanUpdated:
+					store.SetPlayoutJobs(e.Jobs)
+				case events.UpcomingChanged:
+					store.SetUpcomingJobs(e.Jobs)
+				}
 			}
 		}
-	}(jobChannel, upcomingChannel , scheduleChannel )
-	for roomName, address := range playoutServers{
+	}()
+	for roomName, address := range playoutServers {
 		conn, err := grpc.Dial(address, grpc.WithInsecure(), grpc.WithBlock())
 		if err != nil {
 			log.Fatalf("did not connect: %v", err)
 		}
-		store.GrpcClients[roomName] = api.NewPlayoutClient(conn)
+		store.conns[roomName] = conn
+		store.addresses[roomName] = address
+		store.clients[roomName] = api.NewPlayoutClient(conn)
+		go store.monitor(roomName, conn)
+	}
+	return store, done, nil
+}
+
+// Events returns the bus the store listens on.
+func (s *Store) Events() *events.Bus {
+	return s.bus
+}
+
+// Close closes the connections to all playout servers.
+func (s *Store) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		if err := conn.Close(); err != nil {
+			log.Printf("Failed to close connection to %s: %v", conn.Target(), err)
+		}
+	}
+	s.conns = map[string]*grpc.ClientConn{}
+}
+
+// PlayoutClient returns the client of the playout server responsible for room.
+func (s *Store) PlayoutClient(room string) (api.PlayoutClient, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	client, ok := s.clients[room]
+	return client, ok
+}
+
+// ServerStates returns the connectivity state of the playout server of every room.
+func (s *Store) ServerStates() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	states := make(map[string]string, len(s.conns))
+	for room, conn := range s.conns {
+		states[room] = conn.GetState().String()
+	}
+	return states
+}
+
+// SetPlayoutServers connects to new or changed playout servers and disconnects
+// from removed ones. If any server can't be reached before ctx is done, nothing changes.
+func (s *Store) SetPlayoutServers(ctx context.Context, playoutServers map[string]string) error {
+	s.mu.RLock()
+	current := s.addresses
+	s.mu.RUnlock()
+
+	dialed := map[string]*grpc.ClientConn{}
+	for roomName, address := range playoutServers {
+		if current[roomName] == address {
+			continue
+		}
+		conn, err := grpc.DialContext(ctx, address, grpc.WithInsecure(), grpc.WithBlock())
+		if err != nil {
+			for _, c := range dialed {
+				c.Close()
+			}
+			return fmt.Errorf("%s (%s): %w", roomName, address, err)
+		}
+		dialed[roomName] = conn
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := map[string]*grpc.ClientConn{}
+	clients := map[string]api.PlayoutClient{}
+	addresses := map[string]string{}
+	for roomName, address := range playoutServers {
+		conn, ok := dialed[roomName]
+		if !ok {
+			conn = s.conns[roomName]
+		}
+		conns[roomName] = conn
+		clients[roomName] = api.NewPlayoutClient(conn)
+		addresses[roomName] = address
+	}
+	for roomName, conn := range s.conns {
+		if conns[roomName] != conn {
+			conn.Close()
+		}
+	}
+	s.conns = conns
+	s.clients = clients
+	s.addresses = addresses
+	// a new server does not know any of the jobs of the room yet
+	epochs := make(map[string]uint64, len(s.epochs))
+	for room, e := range s.epochs {
+		epochs[room] = e
+	}
+	for roomName, conn := range dialed {
+		epochs[roomName]++
+		go s.monitor(roomName, conn)
+	}
+	s.epochs = epochs
+	if len(dialed) > 0 {
+		select {
+		case s.reconnected <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Snapshot returns the current state of the store.
+func (s *Store) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Revision returns the current revision, it is incremented with every change of the snapshot.
+func (s *Store) Revision() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current.Revision
+}
+
+func (s *Store) PlayoutJobs() map[string]fahrplan.PlayoutJob {
+	return s.Snapshot().PlayoutJobs
+}
+
+func (s *Store) Upcoming() map[string]fahrplan.PlayoutJob {
+	return s.Snapshot().Upcoming
+}
+
+func (s *Store) Scheduled() map[string]api.ScheduledJob {
+	return s.Snapshot().Scheduled
+}
+
+func (s *Store) Excluded() map[string]fahrplan.ExcludedTalk {
+	return s.Snapshot().Excluded
+}
+
+func (s *Store) Unresolved() map[string]UnresolvedRoom {
+	return s.Snapshot().Unresolved
+}
+
+func (s *Store) Lifecycles() map[string]Lifecycle {
+	return s.Snapshot().Lifecycles
+}
+
+// update applies change to a copy of the current snapshot and, if collection differs
+// afterwards, publishes it as the next revision. The caller has to hold the write lock.
+func (s *Store) update(collection Collection, change func(next *Snapshot)) uint64 {
+	next := s.current
+	change(&next)
+	c, ok := diff(s.current.collection(collection), next.collection(collection))
+	if !ok {
+		return s.current.Revision
+	}
+	next.Revision++
+	s.current = next
+	c.Revision = next.Revision
+	c.Collection = collection
+	s.record(c)
+	return next.Revision
+}
+
+// SetPlayoutJobs replaces all jobs, the store takes ownership of playoutJobs.
+func (s *Store) SetPlayoutJobs(playoutJobs map[string]fahrplan.PlayoutJob) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(CollectionPlayoutJobs, func(next *Snapshot) { next.PlayoutJobs = playoutJobs })
+}
+
+// SetUpcomingJobs replaces the upcoming jobs, the store takes ownership of upcomingJobs.
+func (s *Store) SetUpcomingJobs(upcomingJobs map[string]fahrplan.PlayoutJob) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(CollectionUpcoming, func(next *Snapshot) { next.Upcoming = upcomingJobs })
+}
+
+// SetExcludedTalks replaces the excluded talks, the store takes ownership of excludedTalks.
+func (s *Store) SetExcludedTalks(excludedTalks map[string]fahrplan.ExcludedTalk) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(CollectionExcluded, func(next *Snapshot) { next.Excluded = excludedTalks })
+}
+
+// CompareAndSwapScheduled replaces the scheduled jobs only if the store is still at revision.
+// It returns the new revision and whether the swap happened.
+func (s *Store) CompareAndSwapScheduled(revision uint64, scheduledJobs map[string]api.ScheduledJob) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current.Revision != revision {
+		return s.current.Revision, false
+	}
+	return s.update(CollectionScheduled, func(next *Snapshot) { next.Scheduled = scheduledJobs }), true
+}
+
+// UpdateScheduled applies change to a copy of the scheduled jobs and stores it, retrying if
+// the store changed in between. change may be called more than once and must not have side effects.
+func (s *Store) UpdateScheduled(change func(scheduledJobs map[string]api.ScheduledJob)) uint64 {
+	for {
+		snapshot := s.Snapshot()
+		scheduled := make(map[string]api.ScheduledJob, len(snapshot.Scheduled)+1)
+		for id, job := range snapshot.Scheduled {
+			scheduled[id] = job
+		}
+		change(scheduled)
+		if revision, ok := s.CompareAndSwapScheduled(snapshot.Revision, scheduled); ok {
+			return revision
+		}
 	}
-	return store, nil
 }
 
-func (s *Store) SetPlayoutJobs(playoutJobs map[int]fahrplan.PlayoutJob)  {
-	s.Lock()
-	s.PlayoutJobs = playoutJobs
-	s.Unlock()
+// AddUnresolvedRoom records that job guid of room found no playout server.
+// It reports whether room and whether guid were not known as unresolved before.
+func (s *Store) AddUnresolvedRoom(room string, guid string, policy string) (newRoom bool, newJob bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, known := s.current.Unresolved[room]
+	newJob = true
+	for _, j := range u.Jobs {
+		if j == guid {
+			newJob = false
+			break
+		}
+	}
+	s.update(CollectionUnresolved, func(next *Snapshot) {
+		unresolved := make(map[string]UnresolvedRoom, len(next.Unresolved)+1)
+		for k, v := range next.Unresolved {
+			unresolved[k] = v
+		}
+		u.Room = room
+		u.Policy = policy
+		u.LastSeen = time.Now()
+		if newJob {
+			u.Jobs = append(append([]string{}, u.Jobs...), guid)
+		}
+		unresolved[room] = u
+		next.Unresolved = unresolved
+	})
+	return !known, newJob
+}
+
+// PruneUnresolvedRooms passes every unresolved room to resolve, which returns the room with
+// its current policy and whether it is still unresolved. Resolved rooms are forgotten.
+// resolve is called with the store locked and must not use it.
+func (s *Store) PruneUnresolvedRooms(resolve func(u UnresolvedRoom) (UnresolvedRoom, bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.update(CollectionUnresolved, func(next *Snapshot) {
+		unresolved := make(map[string]UnresolvedRoom, len(next.Unresolved))
+		for room, u := range next.Unresolved {
+			if u, ok := resolve(u); ok {
+				unresolved[room] = u
+			}
+		}
+		next.Unresolved = unresolved
+	})
+}
+
+// MarkSubmitted remembers job as the version last sent to a playout server. padding is
+// the configured padding the job got, nil if its padding was chosen explicitly.
+func (s *Store) MarkSubmitted(job fahrplan.PlayoutJob, padding *Padding) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	submitted := make(map[string]fahrplan.PlayoutJob, len(s.submitted)+1)
+	for id, j := range s.submitted {
+		submitted[id] = j
+	}
+	submitted[job.GUID] = job
+	s.submitted = submitted
+	paddings := make(map[string]Padding, len(s.submittedPadding)+1)
+	for id, p := range s.submittedPadding {
+		if id != job.GUID {
+			paddings[id] = p
+		}
+	}
+	if padding != nil {
+		paddings[job.GUID] = *padding
+	}
+	s.submittedPadding = paddings
 }
 
-func (s *Store) SetUpcomingJobs(upcomingJobs map[int]fahrplan.PlayoutJob)  {
-	s.Lock()
-	s.Upcoming = upcomingJobs
-	s.Unlock()
+// SubmittedPadding returns the configured padding the submitted jobs got, for jobs
+// whose padding was not chosen explicitly.
+func (s *Store) SubmittedPadding() map[string]Padding {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.submittedPadding
 }
 
-func (s *Store) SetScheduledJobs(scheduledJobs map[int]api.ScheduledJob)  {
-	s.Lock()
-	s.Scheduled = scheduledJobs
-	s.Unlock()
+// Submitted returns the jobs as they were last sent to the playout servers.
+func (s *Store) Submitted() map[string]fahrplan.PlayoutJob {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.submitted
 }
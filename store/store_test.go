@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/events"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"sync"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *Store {
+	ctx, cancel := context.WithCancel(context.Background())
+	s, done, err := NewStore(ctx, events.NewBus(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	const writers, writes = 4, 50
+	const total = 2 * writers * writes
+	s := newTestStore(t)
+	w, err := s.Watch(0, total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				guid := fmt.Sprintf("scheduled-%d-%d", i, j)
+				s.UpdateScheduled(func(scheduled map[string]api.ScheduledJob) {
+					scheduled[guid] = api.ScheduledJob{ID: int64(i*writes + j), Room: "Saal 1"}
+				})
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				guid := fmt.Sprintf("playout-%d-%d", i, j)
+				s.SetPlayoutJobs(map[string]fahrplan.PlayoutJob{guid: {GUID: guid, ID: i*writes + j}})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if rev := s.Revision(); rev != total {
+		t.Errorf("got revision %d after %d changes", rev, total)
+	}
+	if n := len(s.Scheduled()); n != writers*writes {
+		t.Errorf("got %d scheduled jobs, want %d", n, writers*writes)
+	}
+	for rev := uint64(1); rev <= total; rev++ {
+		c, ok := <-w.Changes()
+		if !ok {
+			t.Fatalf("watcher closed before revision %d, lagged: %v", rev, w.Lagged())
+		}
+		if c.Revision != rev {
+			t.Fatalf("got revision %d, want %d", c.Revision, rev)
+		}
+	}
+}
+
+func TestUnchangedWriteKeepsRevision(t *testing.T) {
+	s := newTestStore(t)
+	jobs := map[string]fahrplan.PlayoutJob{"a": {GUID: "a", ID: 1}}
+	if rev := s.SetPlayoutJobs(jobs); rev != 1 {
+		t.Fatalf("got revision %d, want 1", rev)
+	}
+	if rev := s.SetPlayoutJobs(map[string]fahrplan.PlayoutJob{"a": {GUID: "a", ID: 1}}); rev != 1 {
+		t.Errorf("equal jobs changed the revision to %d", rev)
+	}
+	if rev := s.UpdateScheduled(func(map[string]api.ScheduledJob) {}); rev != 1 {
+		t.Errorf("empty update changed the revision to %d", rev)
+	}
+}
+
+func TestCompareAndSwapScheduled(t *testing.T) {
+	s := newTestStore(t)
+	s.SetPlayoutJobs(map[string]fahrplan.PlayoutJob{"a": {GUID: "a"}})
+	if _, ok := s.CompareAndSwapScheduled(0, map[string]api.ScheduledJob{"a": {ID: 1}}); ok {
+		t.Error("swapped at a stale revision")
+	}
+	rev, ok := s.CompareAndSwapScheduled(1, map[string]api.ScheduledJob{"a": {ID: 1}})
+	if !ok || rev != 2 {
+		t.Errorf("got revision %d, swapped: %v, want 2, true", rev, ok)
+	}
+}
@@ -0,0 +1,39 @@
+// Package storetest provides the store fixtures shared by the tests of other packages.
+package storetest
+
+import (
+	"context"
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/events"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/golang/protobuf/ptypes"
+	"testing"
+	"time"
+)
+
+// New returns a store without playout servers which is stopped when the test ends.
+func New(t *testing.T) *store.Store {
+	ctx, cancel := context.WithCancel(context.Background())
+	s, done, err := store.NewStore(ctx, events.NewBus(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return s
+}
+
+// ScheduledJob returns the answer of the playout server of room for a job from start to stop.
+func ScheduledJob(t *testing.T, room string, start time.Time, stop time.Time) api.ScheduledJob {
+	startAt, err := ptypes.TimestampProto(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopAt, err := ptypes.TimestampProto(stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api.ScheduledJob{StartAt: startAt, StopAt: stopAt, Room: room}
+}
@@ -0,0 +1,165 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+)
+
+// historySize is the number of changes kept for watchers resuming at an older revision.
+const historySize = 1000
+
+type Collection string
+
+const (
+	CollectionPlayoutJobs Collection = "playoutJobs"
+	CollectionUpcoming    Collection = "upcoming"
+	CollectionScheduled   Collection = "scheduled"
+	CollectionExcluded    Collection = "excluded"
+	CollectionUnresolved  Collection = "unresolved"
+	CollectionLifecycles  Collection = "lifecycles"
+)
+
+var (
+	ErrRevisionGone   = errors.New("revision is no longer available")
+	ErrFutureRevision = errors.New("revision does not exist yet")
+)
+
+// Change describes how a collection differs from the previous revision.
+// The values are of the element type of the collection, e.g. fahrplan.PlayoutJob for upcoming.
+type Change struct {
+	Revision   uint64                 `json:"revision"`
+	Collection Collection             `json:"collection"`
+	Added      map[string]interface{} `json:"added,omitempty"`
+	Updated    map[string]interface{} `json:"updated,omitempty"`
+	Removed    []string               `json:"removed,omitempty"`
+}
+
+func (s Snapshot) collection(c Collection) interface{} {
+	switch c {
+	case CollectionPlayoutJobs:
+		return s.PlayoutJobs
+	case CollectionUpcoming:
+		return s.Upcoming
+	case CollectionScheduled:
+		return s.Scheduled
+	case CollectionExcluded:
+		return s.Excluded
+	case CollectionUnresolved:
+		return s.Unresolved
+	case CollectionLifecycles:
+		return s.Lifecycles
+	}
+	return nil
+}
+
+// diff compares two maps with string keys, ok is false if they are equal.
+func diff(old interface{}, new interface{}) (c Change, ok bool) {
+	o, n := reflect.ValueOf(old), reflect.ValueOf(new)
+	iter := n.MapRange()
+	for iter.Next() {
+		key := iter.Key().String()
+		prev := o.MapIndex(iter.Key())
+		switch {
+		case !prev.IsValid():
+			if c.Added == nil {
+				c.Added = map[string]interface{}{}
+			}
+			c.Added[key] = iter.Value().Interface()
+		case !reflect.DeepEqual(prev.Interface(), iter.Value().Interface()):
+			if c.Updated == nil {
+				c.Updated = map[string]interface{}{}
+			}
+			c.Updated[key] = iter.Value().Interface()
+		}
+	}
+	iter = o.MapRange()
+	for iter.Next() {
+		if !n.MapIndex(iter.Key()).IsValid() {
+			c.Removed = append(c.Removed, iter.Key().String())
+		}
+	}
+	sort.Strings(c.Removed)
+	return c, c.Added != nil || c.Updated != nil || c.Removed != nil
+}
+
+// Watcher receives the changes of the store in revision order.
+type Watcher struct {
+	store  *Store
+	ch     chan Change
+	lagged bool
+}
+
+// record keeps c for resuming watchers and hands it to all current ones. Watchers which
+// can't take it are closed as lagged. The caller has to hold the write lock.
+func (s *Store) record(c Change) {
+	s.history = append(s.history, c)
+	if len(s.history) > historySize {
+		s.history = append([]Change(nil), s.history[len(s.history)-historySize:]...)
+	}
+	for w := range s.watchers {
+		select {
+		case w.ch <- c:
+		default:
+			w.lagged = true
+			delete(s.watchers, w)
+			close(w.ch)
+		}
+	}
+}
+
+func (s *Store) watch(backlog []Change, buffer int) *Watcher {
+	w := &Watcher{store: s, ch: make(chan Change, len(backlog)+buffer)}
+	for _, c := range backlog {
+		w.ch <- c
+	}
+	s.watchers[w] = struct{}{}
+	return w
+}
+
+// Watch returns a watcher which first receives all changes after revision since and
+// then every following one. buffer is the number of changes it may fall behind.
+func (s *Store) Watch(since uint64, buffer int) (*Watcher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if since > s.current.Revision {
+		return nil, ErrFutureRevision
+	}
+	var backlog []Change
+	if since < s.current.Revision {
+		if len(s.history) == 0 || s.history[0].Revision > since+1 {
+			return nil, ErrRevisionGone
+		}
+		backlog = s.history[since+1-s.history[0].Revision:]
+	}
+	return s.watch(backlog, buffer), nil
+}
+
+// WatchSnapshot returns the current snapshot and a watcher receiving every change after it.
+func (s *Store) WatchSnapshot(buffer int) (Snapshot, *Watcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current, s.watch(nil, buffer)
+}
+
+// Changes is closed once the watcher was closed or lagged behind.
+func (w *Watcher) Changes() <-chan Change {
+	return w.ch
+}
+
+// Lagged reports whether the watcher got closed because it did not keep up. The
+// changes can be resumed by watching again since the last received revision.
+func (w *Watcher) Lagged() bool {
+	w.store.mu.RLock()
+	defer w.store.mu.RUnlock()
+	return w.lagged
+}
+
+func (w *Watcher) Close() {
+	w.store.mu.Lock()
+	defer w.store.mu.Unlock()
+	if _, ok := w.store.watchers[w]; ok {
+		delete(w.store.watchers, w)
+		close(w.ch)
+	}
+}
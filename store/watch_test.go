@@ -0,0 +1,137 @@
+package store
+
+import (
+	"fmt"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := fahrplan.PlayoutJob{GUID: "a", ID: 1}
+	b := fahrplan.PlayoutJob{GUID: "b", ID: 2}
+	moved := fahrplan.PlayoutJob{GUID: "b", ID: 2, Room: "Saal 2"}
+	tests := []struct {
+		name string
+		old  map[string]fahrplan.PlayoutJob
+		new  map[string]fahrplan.PlayoutJob
+		want Change
+		ok   bool
+	}{
+		{"equal", map[string]fahrplan.PlayoutJob{"a": a}, map[string]fahrplan.PlayoutJob{"a": a}, Change{}, false},
+		{"both empty", map[string]fahrplan.PlayoutJob{}, nil, Change{}, false},
+		{"added", map[string]fahrplan.PlayoutJob{"a": a}, map[string]fahrplan.PlayoutJob{"a": a, "b": b},
+			Change{Added: map[string]interface{}{"b": b}}, true},
+		{"updated", map[string]fahrplan.PlayoutJob{"a": a, "b": b}, map[string]fahrplan.PlayoutJob{"a": a, "b": moved},
+			Change{Updated: map[string]interface{}{"b": moved}}, true},
+		{"removed", map[string]fahrplan.PlayoutJob{"b": b, "a": a}, map[string]fahrplan.PlayoutJob{},
+			Change{Removed: []string{"a", "b"}}, true},
+		{"all at once", map[string]fahrplan.PlayoutJob{"a": a, "b": b}, map[string]fahrplan.PlayoutJob{"b": moved, "c": a},
+			Change{Added: map[string]interface{}{"c": a}, Updated: map[string]interface{}{"b": moved}, Removed: []string{"a"}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := diff(test.old, test.new)
+			if ok != test.ok || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, %v, want %+v, %v", got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func setJobs(s *Store, n int) {
+	for i := 0; i < n; i++ {
+		guid := fmt.Sprintf("job-%d", s.Revision())
+		s.SetPlayoutJobs(map[string]fahrplan.PlayoutJob{guid: {GUID: guid}})
+	}
+}
+
+func TestWatchResume(t *testing.T) {
+	s := newTestStore(t)
+	setJobs(s, 5)
+	w, err := s.Watch(2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	setJobs(s, 2)
+	for rev := uint64(3); rev <= 7; rev++ {
+		c := <-w.Changes()
+		if c.Revision != rev || c.Collection != CollectionPlayoutJobs {
+			t.Fatalf("got revision %d of %s, want %d of %s", c.Revision, c.Collection, rev, CollectionPlayoutJobs)
+		}
+		guid := fmt.Sprintf("job-%d", rev-1)
+		if _, ok := c.Added[guid]; !ok || len(c.Removed) != 1 {
+			t.Errorf("revision %d: got %+v, want %s added and its predecessor removed", rev, c, guid)
+		}
+	}
+	select {
+	case c := <-w.Changes():
+		t.Errorf("got unexpected change %+v", c)
+	default:
+	}
+}
+
+func TestWatchCurrentRevision(t *testing.T) {
+	s := newTestStore(t)
+	setJobs(s, 3)
+	w, err := s.Watch(3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	select {
+	case c := <-w.Changes():
+		t.Fatalf("got backlog %+v for the current revision", c)
+	default:
+	}
+	setJobs(s, 1)
+	if c := <-w.Changes(); c.Revision != 4 {
+		t.Errorf("got revision %d, want 4", c.Revision)
+	}
+}
+
+func TestWatchFutureRevision(t *testing.T) {
+	s := newTestStore(t)
+	setJobs(s, 3)
+	if _, err := s.Watch(4, 1); err != ErrFutureRevision {
+		t.Errorf("got %v, want %v", err, ErrFutureRevision)
+	}
+}
+
+func TestWatchRevisionGone(t *testing.T) {
+	s := newTestStore(t)
+	setJobs(s, historySize+10)
+	for _, since := range []uint64{0, 9} {
+		if _, err := s.Watch(since, 1); err != ErrRevisionGone {
+			t.Errorf("since %d: got %v, want %v", since, err, ErrRevisionGone)
+		}
+	}
+	w, err := s.Watch(10, 0)
+	if err != nil {
+		t.Fatalf("oldest kept revision: %v", err)
+	}
+	defer w.Close()
+	if c := <-w.Changes(); c.Revision != 11 {
+		t.Errorf("got revision %d, want 11", c.Revision)
+	}
+}
+
+func TestWatchLagged(t *testing.T) {
+	s := newTestStore(t)
+	w, err := s.Watch(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setJobs(s, 2)
+	if c, ok := <-w.Changes(); !ok || c.Revision != 1 {
+		t.Fatalf("got revision %d, open: %v, want 1", c.Revision, ok)
+	}
+	if _, ok := <-w.Changes(); ok {
+		t.Fatal("watcher was not closed after falling behind")
+	}
+	if !w.Lagged() {
+		t.Error("watcher is not marked as lagged")
+	}
+	w.Close()
+}
@@ -0,0 +1,113 @@
+package studio
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"time"
+)
+
+// Assignment makes Studio cover every talk in Room starting within [From, To).
+// A zero To leaves the assignment open ended.
+type Assignment struct {
+	Room   string    `json:"room" yaml:"room"`
+	Studio string    `json:"studio" yaml:"studio"`
+	From   time.Time `json:"from" yaml:"from"`
+	To     time.Time `json:"to,omitempty" yaml:"to,omitempty"`
+
+	// line is where the assignment is defined in its file
+	line int
+}
+
+// assignmentColumns lists the CSV columns, to may be left out for open ended assignments.
+var assignmentColumns = map[string][]string{
+	"room":   {"room"},
+	"studio": {"studio", "studio_name"},
+	"from":   {"from", "start"},
+	"to":     {"to", "end", "until"},
+}
+
+func (a Assignment) covers(room string, start time.Time) bool {
+	return a.Room == room && !start.Before(a.From) && (a.To.IsZero() || start.Before(a.To))
+}
+
+func (a Assignment) overlaps(b Assignment) bool {
+	if a.Room != b.Room {
+		return false
+	}
+	aEndsFirst := !a.To.IsZero() && !a.To.After(b.From)
+	bEndsFirst := !b.To.IsZero() && !b.To.After(a.From)
+	return !aEndsFirst && !bEndsFirst
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func addAssignment(assignments []Assignment, a Assignment) ([]Assignment, error) {
+	if a.Room == "" || a.Studio == "" {
+		return assignments, fmt.Errorf("assignment needs a room and a studio")
+	}
+	if a.From.IsZero() {
+		return assignments, fmt.Errorf("assignment of %s to %s has no start", a.Studio, a.Room)
+	}
+	if !a.To.IsZero() && !a.To.After(a.From) {
+		return assignments, fmt.Errorf("assignment of %s to %s ends before it starts", a.Studio, a.Room)
+	}
+	for _, b := range assignments {
+		if a.overlaps(b) {
+			return assignments, fmt.Errorf("assignment of %s to %s overlaps with %s from %s", a.Studio, a.Room, b.Studio, b.From.Format(time.RFC3339))
+		}
+	}
+	return append(assignments, a), nil
+}
+
+// LoadAssignments reads time based studio assignments, an empty file name yields none.
+func LoadAssignments(file string) ([]Assignment, []error, error) {
+	var assignments []Assignment
+	if file == "" {
+		return assignments, nil, nil
+	}
+	if formatOf(file) != formatCSV {
+		errs, err := documentEntries(file, func(node *yaml.Node) error {
+			var a Assignment
+			if err := node.Decode(&a); err != nil {
+				return err
+			}
+			a.line = node.Line
+			var err error
+			assignments, err = addAssignment(assignments, a)
+			return err
+		})
+		sortAssignments(assignments)
+		return assignments, errs, err
+	}
+
+	rows, lines, errs, err := csvRows(file, assignmentColumns, []string{"room", "studio", "from", "to"}, map[string]bool{"to": true})
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, row := range rows {
+		a := Assignment{Room: row["room"], Studio: row["studio"], line: lines[i]}
+		if a.From, err = parseTime(row["from"]); err != nil {
+			errs = append(errs, RowError{file, lines[i], fmt.Sprintf("invalid start: %v", err)})
+			continue
+		}
+		if a.To, err = parseTime(row["to"]); err != nil {
+			errs = append(errs, RowError{file, lines[i], fmt.Sprintf("invalid end: %v", err)})
+			continue
+		}
+		if assignments, err = addAssignment(assignments, a); err != nil {
+			errs = append(errs, RowError{file, lines[i], err.Error()})
+		}
+	}
+	sortAssignments(assignments)
+	return assignments, errs, nil
+}
+
+func sortAssignments(assignments []Assignment) {
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].From.Before(assignments[j].From) })
+}
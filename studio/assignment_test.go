@@ -0,0 +1,40 @@
+package studio
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadAssignmentsCSV(t *testing.T) {
+	from := time.Date(2020, 12, 27, 10, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	tests := []struct {
+		name string
+		data string
+		to   time.Time
+	}{
+		{"header", "room,studio,from,to\nSaal 1,A,2020-12-27T10:00:00Z,2020-12-27T11:00:00Z\n", to},
+		{"header with empty to", "room,studio,from,to\nSaal 1,A,2020-12-27T10:00:00Z,\n", time.Time{}},
+		{"header without to", "room,studio,from\nSaal 1,A,2020-12-27T10:00:00Z\n", time.Time{}},
+		{"positional", "Saal 1,A,2020-12-27T10:00:00Z,2020-12-27T11:00:00Z\n", to},
+		{"positional without to", "Saal 1,A,2020-12-27T10:00:00Z\n", time.Time{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "assignments.csv")
+			writeFile(t, file, test.data)
+			assignments, errs, err := LoadAssignments(file)
+			if err != nil || len(errs) > 0 {
+				t.Fatalf("got errors %v, %v", err, errs)
+			}
+			if len(assignments) != 1 {
+				t.Fatalf("got %d assignments, want 1", len(assignments))
+			}
+			a := assignments[0]
+			if a.Room != "Saal 1" || a.Studio != "A" || !a.From.Equal(from) || !a.To.Equal(test.to) {
+				t.Errorf("got %+v", a)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package studio
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrStudioExists  = errors.New("studio already exists")
+	ErrStudioInUse   = errors.New("studio still has talks or rooms assigned")
+	ErrUnknownStudio = errors.New("unknown studio")
+	ErrInvalidStudio = errors.New("studio needs a name and an ingest URL")
+	ErrInvalidRows   = errors.New("mapping files contain invalid rows, fix them before editing")
+	ErrNoTalkFile    = errors.New("no talk mapping file is configured")
+)
+
+func (m *Mapping) clone() *Mapping {
+	c := &Mapping{
+		Talks:       make(map[int]string, len(m.Talks)),
+		Studios:     make(map[string]Studio, len(m.Studios)),
+		Assignments: m.Assignments,
+	}
+	for id, name := range m.Talks {
+		c.Talks[id] = name
+	}
+	for name, studio := range m.Studios {
+		c.Studios[name] = studio
+	}
+	return c
+}
+
+// writeFiles replaces all files atomically, so the Watcher never sees a partially written file.
+// Every file is written to a temporary file first, so a failing write leaves all of them untouched.
+func writeFiles(files map[string][]byte) error {
+	tmps := map[string]string{}
+	defer func() {
+		for _, tmp := range tmps {
+			os.Remove(tmp)
+		}
+	}()
+	for file, data := range files {
+		tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file))
+		if err != nil {
+			return err
+		}
+		tmps[file] = tmp.Name()
+		if _, err := tmp.Write(data); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+	}
+	for file, tmp := range tmps {
+		if err := os.Rename(tmp, file); err != nil {
+			return err
+		}
+		delete(tmps, file)
+	}
+	return nil
+}
+
+// encode serializes records in the format of file. CSV files get header, followed by rows.
+func encode(file string, records interface{}, header []string, rows [][]string) ([]byte, error) {
+	switch formatOf(file) {
+	case formatYAML:
+		return yaml.Marshal(records)
+	case formatJSON:
+		return json.MarshalIndent(records, "", "  ")
+	default:
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		if err := w.WriteAll(append([][]string{header}, rows...)); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+}
+
+func (w *Watcher) persist(m *Mapping) error {
+	studios := make([]Studio, 0, len(m.Studios))
+	for _, s := range m.Studios {
+		studios = append(studios, s)
+	}
+	sort.Slice(studios, func(i, j int) bool { return studios[i].Name < studios[j].Name })
+	studioRows := make([][]string, 0, len(studios))
+	for _, s := range studios {
+		studioRows = append(studioRows, []string{s.ID, s.Name, s.IngestURL})
+	}
+
+	ids := make([]int, 0, len(m.Talks))
+	for id := range m.Talks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	talks := make([]talkRecord, 0, len(ids))
+	talkRows := make([][]string, 0, len(ids))
+	for _, id := range ids {
+		talks = append(talks, talkRecord{Talk: id, Studio: m.Talks[id]})
+		talkRows = append(talkRows, []string{strconv.Itoa(id), m.Talks[id]})
+	}
+
+	studioData, err := encode(w.files.Studios, studios, []string{"id", "name", "ingest_url"}, studioRows)
+	if err != nil {
+		return err
+	}
+	talkData, err := encode(w.files.Talks, talks, []string{"talk", "studio"}, talkRows)
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{w.files.Studios: studioData}
+	if w.files.Talks != "" {
+		files[w.files.Talks] = talkData
+	} else if len(m.Talks) > 0 {
+		return ErrNoTalkFile
+	}
+	return writeFiles(files)
+}
+
+// update applies fn to a copy of the active mapping, writes the result back to
+// the mapping files and activates them. As rewriting the files would drop the rows
+// which failed to load, files with invalid rows are not edited. The files are read
+// back, so the reported problems stay accurate, e.g. for the assignments file.
+func (w *Watcher) update(fn func(m *Mapping) error) error {
+	w.editMu.Lock()
+	defer w.editMu.Unlock()
+	if w.Mapping().skipped {
+		return ErrInvalidRows
+	}
+	m := w.Mapping().clone()
+	if err := fn(m); err != nil {
+		return err
+	}
+	if err := w.persist(m); err != nil {
+		return err
+	}
+	if err := w.load(); err != nil {
+		return err
+	}
+	w.notify()
+	return nil
+}
+
+func (w *Watcher) Studios() []Studio {
+	m := w.Mapping()
+	studios := make([]Studio, 0, len(m.Studios))
+	for _, s := range m.Studios {
+		studios = append(studios, s)
+	}
+	sort.Slice(studios, func(i, j int) bool { return studios[i].Name < studios[j].Name })
+	return studios
+}
+
+func (w *Watcher) CreateStudio(studio Studio) error {
+	return w.update(func(m *Mapping) error {
+		if studio.Name == "" || studio.IngestURL == "" {
+			return ErrInvalidStudio
+		}
+		if _, ok := m.Studios[studio.Name]; ok {
+			return ErrStudioExists
+		}
+		m.Studios[studio.Name] = studio
+		return nil
+	})
+}
+
+// PutStudio creates or replaces the studio called name.
+func (w *Watcher) PutStudio(name string, studio Studio) error {
+	return w.update(func(m *Mapping) error {
+		studio.Name = name
+		if studio.Name == "" || studio.IngestURL == "" {
+			return ErrInvalidStudio
+		}
+		m.Studios[name] = studio
+		return nil
+	})
+}
+
+func (w *Watcher) DeleteStudio(name string) error {
+	return w.update(func(m *Mapping) error {
+		if _, ok := m.Studios[name]; !ok {
+			return ErrNotFound
+		}
+		for _, s := range m.Talks {
+			if s == name {
+				return ErrStudioInUse
+			}
+		}
+		for _, a := range m.Assignments {
+			if a.Studio == name {
+				return ErrStudioInUse
+			}
+		}
+		delete(m.Studios, name)
+		return nil
+	})
+}
+
+func (w *Watcher) TalkStudio(id int) (Studio, error) {
+	m := w.Mapping()
+	name, ok := m.Talks[id]
+	if !ok {
+		return Studio{}, ErrNotFound
+	}
+	studio, ok := m.Studios[name]
+	if !ok {
+		return Studio{Name: name}, ErrUnknownStudio
+	}
+	return studio, nil
+}
+
+func (w *Watcher) AssignTalk(id int, name string) error {
+	return w.update(func(m *Mapping) error {
+		if _, ok := m.Studios[name]; !ok {
+			return ErrUnknownStudio
+		}
+		m.Talks[id] = name
+		return nil
+	})
+}
+
+func (w *Watcher) UnassignTalk(id int) error {
+	return w.update(func(m *Mapping) error {
+		if _, ok := m.Talks[id]; !ok {
+			return ErrNotFound
+		}
+		delete(m.Talks, id)
+		return nil
+	})
+}
@@ -0,0 +1,48 @@
+package studio
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, file string, data string) {
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteStudioInUse(t *testing.T) {
+	dir := t.TempDir()
+	files := Files{
+		Talks:       filepath.Join(dir, "talks.csv"),
+		Studios:     filepath.Join(dir, "studios.csv"),
+		Assignments: filepath.Join(dir, "assignments.csv"),
+	}
+	writeFile(t, files.Talks, "talk,studio\n1,A\n")
+	writeFile(t, files.Studios, "id,name,ingest_url\na,A,rtmp://a\nb,B,rtmp://b\nc,C,rtmp://c\n")
+	writeFile(t, files.Assignments, "room,studio,from,to\nSaal 1,B,2020-12-27T10:00:00Z,\nSaal 2,D,2020-12-27T10:00:00Z,\n")
+	w, err := NewWatcher(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, errs := w.Errors(); len(errs) != 1 {
+		t.Fatalf("got errors %v, want the assignment to unknown studio D", errs)
+	}
+
+	for _, name := range []string{"A", "B"} {
+		if err := w.DeleteStudio(name); !errors.Is(err, ErrStudioInUse) {
+			t.Errorf("deleting %s: got %v, want %v", name, err, ErrStudioInUse)
+		}
+	}
+	if err := w.DeleteStudio("C"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.Mapping().Studios["C"]; ok {
+		t.Error("studio C was not deleted")
+	}
+	if _, errs := w.Errors(); len(errs) != 1 {
+		t.Errorf("got errors %v after an edit, want the assignment to unknown studio D", errs)
+	}
+}
@@ -0,0 +1,255 @@
+package studio
+
+import (
+	"encoding/csv"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type format int
+
+const (
+	formatCSV format = iota
+	formatYAML
+	formatJSON
+)
+
+func formatOf(file string) format {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml":
+		return formatYAML
+	case ".json":
+		return formatJSON
+	default:
+		return formatCSV
+	}
+}
+
+// talkRecord is a single talk assignment in a YAML or JSON mapping file.
+type talkRecord struct {
+	Talk   int    `yaml:"talk" json:"talk"`
+	Studio string `yaml:"studio" json:"studio"`
+}
+
+// Accepted CSV header names per column, compared case-insensitively.
+var (
+	talkColumns = map[string][]string{
+		"talk":   {"talk", "id", "talk_id", "talkid"},
+		"studio": {"studio", "studio_name"},
+	}
+	studioColumns = map[string][]string{
+		"id":     {"id", "studio_id"},
+		"name":   {"name", "studio"},
+		"ingest": {"ingesturl", "ingest_url", "ingest", "url"},
+	}
+)
+
+// headerColumns returns the index of every column if row is a header naming all of them,
+// except for the optional ones.
+func headerColumns(row []string, columns map[string][]string, optional map[string]bool) (map[string]int, bool) {
+	index := map[string]int{}
+	for i, cell := range row {
+		cell = strings.ToLower(strings.TrimSpace(cell))
+		for column, names := range columns {
+			for _, name := range names {
+				if _, ok := index[column]; !ok && cell == name {
+					index[column] = i
+				}
+			}
+		}
+	}
+	for column := range columns {
+		if _, ok := index[column]; !ok && !optional[column] {
+			return index, false
+		}
+	}
+	return index, true
+}
+
+// csvRows returns every row as a map of column name to value. Files without a
+// header use the legacy column order given by positional. Optional columns may
+// be missing from the header or the row, they are left empty then.
+func csvRows(file string, columns map[string][]string, positional []string, optional map[string]bool) ([]map[string]string, []int, []error, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	index := map[string]int{}
+	for i, column := range positional {
+		index[column] = i
+	}
+	first := 0
+	if len(records) > 0 {
+		if header, ok := headerColumns(records[0], columns, optional); ok {
+			index = header
+			first = 1
+		}
+	}
+
+	var rows []map[string]string
+	var lines []int
+	var errs []error
+	for i := first; i < len(records); i++ {
+		record := records[i]
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		row := map[string]string{}
+		for column, idx := range index {
+			if idx >= len(record) {
+				if optional[column] {
+					continue
+				}
+				errs = append(errs, RowError{file, i + 1, fmt.Sprintf("missing column %s, got %d columns", column, len(record))})
+				row = nil
+				break
+			}
+			row[column] = strings.TrimSpace(record[idx])
+		}
+		if row != nil {
+			rows = append(rows, row)
+			lines = append(lines, i+1)
+		}
+	}
+	return rows, lines, errs, nil
+}
+
+// documentEntries decodes a YAML or JSON list, calling fn with every element and its line.
+func documentEntries(file string, fn func(node *yaml.Node) error) ([]error, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	list := doc.Content[0]
+	if list.Kind != yaml.SequenceNode {
+		return nil, RowError{file, list.Line, "expected a list of entries"}
+	}
+	var errs []error
+	for _, node := range list.Content {
+		if err := fn(node); err != nil {
+			errs = append(errs, RowError{file, node.Line, err.Error()})
+		}
+	}
+	return errs, nil
+}
+
+func addTalk(talks map[int]string, id int, studio string) error {
+	if id <= 0 {
+		return fmt.Errorf("talk ID has to be a positive number, got %d", id)
+	}
+	if studio == "" {
+		return fmt.Errorf("talk %d has no studio", id)
+	}
+	if existing, ok := talks[id]; ok {
+		return fmt.Errorf("talk %d is already assigned to %s", id, existing)
+	}
+	talks[id] = studio
+	return nil
+}
+
+func addStudio(studios map[string]Studio, studio Studio) error {
+	if studio.Name == "" {
+		return fmt.Errorf("studio has no name")
+	}
+	if studio.IngestURL == "" {
+		return fmt.Errorf("studio %s has no ingest URL", studio.Name)
+	}
+	if _, ok := studios[studio.Name]; ok {
+		return fmt.Errorf("studio %s is defined twice", studio.Name)
+	}
+	studios[studio.Name] = studio
+	return nil
+}
+
+func LoadTalks(file string) (map[int]string, []error, error) {
+	talks, _, errs, err := loadTalks(file)
+	return talks, errs, err
+}
+
+// loadTalks also returns the line every talk was defined on. An empty file name yields no talks.
+func loadTalks(file string) (map[int]string, map[int]int, []error, error) {
+	talks := map[int]string{}
+	lines := map[int]int{}
+	if file == "" {
+		return talks, lines, nil, nil
+	}
+	if formatOf(file) != formatCSV {
+		errs, err := documentEntries(file, func(node *yaml.Node) error {
+			var t talkRecord
+			if err := node.Decode(&t); err != nil {
+				return err
+			}
+			if err := addTalk(talks, t.Talk, strings.TrimSpace(t.Studio)); err != nil {
+				return err
+			}
+			lines[t.Talk] = node.Line
+			return nil
+		})
+		return talks, lines, errs, err
+	}
+
+	rows, rowLines, errs, err := csvRows(file, talkColumns, []string{"talk", "studio"}, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for i, row := range rows {
+		id, err := strconv.Atoi(row["talk"])
+		if err != nil {
+			errs = append(errs, RowError{file, rowLines[i], fmt.Sprintf("could'nt parse %v to integer", row["talk"])})
+			continue
+		}
+		if err := addTalk(talks, id, row["studio"]); err != nil {
+			errs = append(errs, RowError{file, rowLines[i], err.Error()})
+			continue
+		}
+		lines[id] = rowLines[i]
+	}
+	return talks, lines, errs, nil
+}
+
+func LoadStudios(file string) (map[string]Studio, []error, error) {
+	studios := map[string]Studio{}
+	if formatOf(file) != formatCSV {
+		errs, err := documentEntries(file, func(node *yaml.Node) error {
+			var s Studio
+			if err := node.Decode(&s); err != nil {
+				return err
+			}
+			return addStudio(studios, s)
+		})
+		return studios, errs, err
+	}
+
+	rows, lines, errs, err := csvRows(file, studioColumns, []string{"id", "name", "ingest"}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, row := range rows {
+		s := Studio{ID: row["id"], Name: row["name"], IngestURL: row["ingest"]}
+		if err := addStudio(studios, s); err != nil {
+			errs = append(errs, RowError{file, lines[i], err.Error()})
+		}
+	}
+	return studios, errs, nil
+}
@@ -0,0 +1,91 @@
+package studio
+
+import (
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"time"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+type Studio struct {
+	ID        string `json:"id" yaml:"id"`
+	Name      string `json:"name" yaml:"name"`
+	IngestURL string `json:"ingestUrl" yaml:"ingestUrl"`
+}
+
+// Mapping assigns talks to studios and studios to their ingest URL. Talks
+// without an entry in Talks get the studio assigned to their room at their start.
+// A Mapping is never modified after it was loaded, changes create a new one.
+type Mapping struct {
+	Talks       map[int]string
+	Studios     map[string]Studio
+	Assignments []Assignment
+
+	// skipped is set if rows of the talk or studio file were invalid and are missing from the mapping
+	skipped bool
+}
+
+// Files names the mapping files. Either Talks or Assignments may be left empty.
+type Files struct {
+	Talks       string
+	Studios     string
+	Assignments string
+}
+
+// RowError describes a row which was skipped while loading a mapping file.
+type RowError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// Load reads all mapping files. Malformed or inconsistent rows are skipped and
+// returned as errs, err is only set if one of the files could not be read at all.
+func Load(files Files) (m *Mapping, errs []error, err error) {
+	talks, talkLines, talkErrs, err := loadTalks(files.Talks)
+	if err != nil {
+		return nil, nil, err
+	}
+	studios, studioErrs, err := LoadStudios(files.Studios)
+	if err != nil {
+		return nil, nil, err
+	}
+	assignments, assignmentErrs, err := LoadAssignments(files.Assignments)
+	if err != nil {
+		return nil, nil, err
+	}
+	errs = append(append(talkErrs, studioErrs...), assignmentErrs...)
+	for id, name := range talks {
+		if _, ok := studios[name]; !ok {
+			errs = append(errs, RowError{files.Talks, talkLines[id], fmt.Sprintf("talk %d is assigned to unknown studio %s", id, name)})
+		}
+	}
+	for _, a := range assignments {
+		if _, ok := studios[a.Studio]; !ok {
+			errs = append(errs, RowError{files.Assignments, a.line, fmt.Sprintf("room %s is assigned to unknown studio %s", a.Room, a.Studio)})
+		}
+	}
+	skipped := len(talkErrs) > 0 || len(studioErrs) > 0
+	return &Mapping{Talks: talks, Studios: studios, Assignments: assignments, skipped: skipped}, errs, nil
+}
+
+// IngestURL resolves the ingest URL of a talk. Per talk assignments take
+// precedence over the room assignments.
+func (m *Mapping) IngestURL(talkID int, room string, start time.Time) (string, bool) {
+	if name, ok := m.Talks[talkID]; ok {
+		studio, ok := m.Studios[name]
+		return studio.IngestURL, ok
+	}
+	for _, a := range m.Assignments {
+		if a.covers(room, start) {
+			studio, ok := m.Studios[a.Studio]
+			return studio.IngestURL, ok
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,135 @@
+package studio
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Watcher keeps a Mapping up to date with the files on disk.
+type Watcher struct {
+	files Files
+
+	editMu  sync.Mutex
+	mu      sync.RWMutex
+	mapping *Mapping
+	errs    []error
+	loaded  time.Time
+	states  [3]fileState
+	changed chan struct{}
+}
+
+func NewWatcher(files Files) (*Watcher, error) {
+	w := &Watcher{
+		files:   files,
+		changed: make(chan struct{}, 1),
+	}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func stat(file string) fileState {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}
+}
+
+func (f Files) states() [3]fileState {
+	return [3]fileState{stat(f.Talks), stat(f.Studios), stat(f.Assignments)}
+}
+
+func (w *Watcher) reload() error {
+	w.editMu.Lock()
+	defer w.editMu.Unlock()
+	return w.load()
+}
+
+// load activates the mapping in the files, the caller has to hold editMu.
+func (w *Watcher) load() error {
+	states := w.files.states()
+	m, errs, err := Load(w.files)
+	if err != nil {
+		return err
+	}
+	for _, e := range errs {
+		log.Printf("Mapping: %v", e)
+	}
+	w.mu.Lock()
+	w.mapping = m
+	w.errs = errs
+	w.loaded = time.Now()
+	w.states = states
+	w.mu.Unlock()
+	return nil
+}
+
+// Mapping returns the currently active mapping.
+func (w *Watcher) Mapping() *Mapping {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.mapping
+}
+
+// Errors returns the problems found while loading the active mapping.
+func (w *Watcher) Errors() (time.Time, []error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.loaded, w.errs
+}
+
+// Changed receives a value every time a new mapping got activated.
+func (w *Watcher) Changed() <-chan struct{} {
+	return w.changed
+}
+
+func (w *Watcher) notify() {
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Watcher) modified() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.files.states() != w.states
+}
+
+// Watch polls all files every interval and reloads them after they changed until ctx is done.
+// If a file can't be read, the previous mapping stays active.
+func (w *Watcher) Watch(ctx context.Context, interval time.Duration) chan struct{} {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ticker.C:
+				if !w.modified() {
+					continue
+				}
+				if err := w.reload(); err != nil {
+					log.Printf("Failed to reload mapping, keeping the previous one: %v", err)
+					continue
+				}
+				log.Println("Reloaded talk and studio mapping")
+				w.notify()
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return done
+}
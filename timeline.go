@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/Garionion/ffmpeg-playout/api"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/golang/protobuf/ptypes"
+	"sort"
+	"time"
+)
+
+type timelineEntry struct {
+	fahrplan.PlayoutJob
+	Scheduled *api.ScheduledJob `json:"scheduled"`
+	State     store.JobState    `json:"state,omitempty"`
+}
+
+func (e timelineEntry) start() time.Time {
+	if !e.Start.IsZero() || e.Scheduled == nil {
+		return e.Start
+	}
+	start, err := ptypes.Timestamp(e.Scheduled.StartAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return start
+}
+
+func roomTimeline(s *store.Store, room string) []timelineEntry {
+	snapshot := s.Snapshot()
+	jobs := snapshot.PlayoutJobs
+	scheduled := snapshot.Scheduled
+
+	timeline := []timelineEntry{}
+	for guid, job := range jobs {
+		if job.Room != room {
+			continue
+		}
+		entry := timelineEntry{PlayoutJob: job, State: snapshot.Lifecycles[guid].State}
+		if sj, ok := scheduled[guid]; ok {
+			entry.Scheduled = &sj
+		}
+		timeline = append(timeline, entry)
+	}
+	// manually scheduled jobs do not have to be part of the Fahrplan
+	for guid, sj := range scheduled {
+		if _, ok := jobs[guid]; ok || sj.Room != room {
+			continue
+		}
+		sj := sj
+		timeline = append(timeline, timelineEntry{
+			PlayoutJob: fahrplan.PlayoutJob{GUID: guid, Room: room, Version: sj.Version},
+			Scheduled:  &sj,
+			State:      snapshot.Lifecycles[guid].State,
+		})
+	}
+	sort.Slice(timeline, func(i, j int) bool {
+		return timeline[i].start().Before(timeline[j].start())
+	})
+	return timeline
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Garionion/playout-controller/studio"
+	"github.com/ilyakaznacheev/cleanenv"
+	"os"
+)
+
+// validateMappings implements the validate-mappings command. Files given as
+// flags take precedence over the ones named in the configuration.
+func validateMappings(args []string) int {
+	fs := flag.NewFlagSet("validate-mappings", flag.ExitOnError)
+	configFile := fs.String("config", "config.yml", "configuration naming the mapping files")
+	talkFile := fs.String("talks", "", "talk to studio mapping file")
+	studioFile := fs.String("studios", "", "studio to ingest URL mapping file")
+	assignmentFile := fs.String("assignments", "", "time based studio to room assignment file")
+	_ = fs.Parse(args)
+
+	files := studio.Files{Talks: *talkFile, Studios: *studioFile, Assignments: *assignmentFile}
+	if files.Talks == "" || files.Studios == "" {
+		cfg := new(Configuration)
+		if err := cleanenv.ReadConfig(*configFile, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to load Config: ", err)
+			return 2
+		}
+		if files.Talks == "" {
+			files.Talks = cfg.TalkIDtoStudioFile
+		}
+		if files.Studios == "" {
+			files.Studios = cfg.StudioIngestURLFile
+		}
+		if files.Assignments == "" {
+			files.Assignments = cfg.StudioAssignmentFile
+		}
+	}
+
+	m, errs, err := studio.Load(files)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, e := range errs {
+		fmt.Fprintln(os.Stderr, e)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems found\n", len(errs))
+		return 1
+	}
+	fmt.Printf("%d studios, %d talks and %d room assignments are valid\n", len(m.Studios), len(m.Talks), len(m.Assignments))
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/Garionion/playout-controller/store"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"time"
+)
+
+// watchStart returns the revision a watch resumes at, taken from since or the
+// Last-Event-ID header of a reconnecting EventSource. ok is false for a fresh watch.
+func watchStart(c *fiber.Ctx) (since uint64, ok bool, err error) {
+	value := c.Query("since")
+	if value == "" {
+		value = c.Get("Last-Event-ID")
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+	since, err = strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, fiber.NewError(fiber.StatusBadRequest, "since has to be a revision")
+	}
+	return since, true, nil
+}
+
+func registerWatchRoutes(ctx context.Context, api fiber.Router, s *store.Store) {
+	// /watch streams the changes of the store as server-sent events with the revision as id.
+	// Without since, the stream starts with a snapshot of the store.
+	api.Get("/watch", func(c *fiber.Ctx) error {
+		since, resume, err := watchStart(c)
+		if err != nil {
+			return err
+		}
+		var snapshot *store.Snapshot
+		var watcher *store.Watcher
+		if resume {
+			watcher, err = s.Watch(since, 256)
+			switch {
+			case errors.Is(err, store.ErrRevisionGone):
+				return fiber.NewError(fiber.StatusGone, err.Error())
+			case errors.Is(err, store.ErrFutureRevision):
+				return fiber.NewError(fiber.StatusBadRequest, err.Error())
+			case err != nil:
+				return err
+			}
+		} else {
+			current, w := s.WatchSnapshot(256)
+			snapshot, watcher = &current, w
+		}
+		setSSEHeaders(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer watcher.Close()
+			if snapshot != nil {
+				if writeSSE(w, strconv.FormatUint(snapshot.Revision, 10), "snapshot", snapshot) != nil {
+					return
+				}
+			}
+			ticker := time.NewTicker(keepaliveInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case change, ok := <-watcher.Changes():
+					if !ok {
+						// lagged behind, the client reconnects with Last-Event-ID
+						return
+					}
+					if writeSSE(w, strconv.FormatUint(change.Revision, 10), "change", change) != nil {
+						return
+					}
+				case <-ticker.C:
+					if keepalive(w) != nil {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+		return nil
+	})
+}
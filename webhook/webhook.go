@@ -0,0 +1,283 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Garionion/playout-controller/events"
+	"github.com/Garionion/playout-controller/metrics"
+	jsoniter "github.com/json-iterator/go"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+)
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+const (
+	defaultRetries = 3
+	defaultTimeout = 5 * time.Second
+	maxBackoff     = time.Minute
+	// queueSize is the number of events a hook may fall behind before new ones are dropped.
+	queueSize = 64
+)
+
+// initialBackoff is the delay before the first retry, it doubles with every further one.
+var initialBackoff = time.Second
+
+// Config describes an outgoing webhook. Without Events, it receives every event
+// except the frequent fahrplanUpdated, upcomingChanged and jobStateChanged ones. Template renders the
+// JSON payload from the events.Event, by default the event itself is sent.
+type Config struct {
+	URL      string        `yaml:"URL"`
+	Events   []string      `yaml:"Events,omitempty"`
+	Secret   string        `yaml:"Secret,omitempty"`
+	Template string        `yaml:"Template,omitempty"`
+	Retries  *int          `yaml:"Retries,omitempty"`
+	Timeout  time.Duration `yaml:"Timeout,omitempty"`
+}
+
+// Hook is a validated webhook.
+type Hook struct {
+	Config
+	events   map[events.Type]bool
+	template *template.Template
+	retries  int
+}
+
+var funcs = template.FuncMap{
+	// json quotes a value for use inside the JSON payload
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Compile validates all webhooks.
+func Compile(configs []Config) ([]*Hook, error) {
+	hooks := make([]*Hook, 0, len(configs))
+	for i, c := range configs {
+		if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook %d: URL %q is not a valid http(s) URL", i, c.URL)
+		}
+		h := &Hook{Config: c, retries: defaultRetries}
+		if c.Retries != nil {
+			if *c.Retries < 0 {
+				return nil, fmt.Errorf("webhook %d: Retries must not be negative", i)
+			}
+			h.retries = *c.Retries
+		}
+		if h.Timeout == 0 {
+			h.Timeout = defaultTimeout
+		}
+		if h.Timeout < 0 {
+			return nil, fmt.Errorf("webhook %d: Timeout must not be negative", i)
+		}
+		if len(c.Events) > 0 {
+			h.events = map[events.Type]bool{}
+			for _, name := range c.Events {
+				t, err := events.ParseType(name)
+				if err != nil {
+					return nil, fmt.Errorf("webhook %d: %w", i, err)
+				}
+				h.events[t] = true
+			}
+		}
+		if c.Template != "" {
+			t, err := template.New(fmt.Sprintf("webhook %d", i)).Funcs(funcs).Parse(c.Template)
+			if err != nil {
+				return nil, err
+			}
+			h.template = t
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// Redacted returns c without its secret and with the URL reduced to its host,
+// as webhook URLs often contain access tokens.
+func (c Config) Redacted() Config {
+	if u, err := url.Parse(c.URL); err == nil {
+		c.URL = u.Scheme + "://" + u.Host + "/..."
+	}
+	if c.Secret != "" {
+		c.Secret = "xxxxx"
+	}
+	return c
+}
+
+// Wants reports whether the hook is interested in events of type t.
+func (h *Hook) Wants(t events.Type) bool {
+	if h.events == nil {
+		return t != events.FahrplanUpdated && t != events.UpcomingChanged && t != events.JobStateChanged
+	}
+	return h.events[t]
+}
+
+func (h *Hook) payload(e events.Event) ([]byte, error) {
+	if h.template == nil {
+		return json.Marshal(e)
+	}
+	var b bytes.Buffer
+	if err := h.template.Execute(&b, e); err != nil {
+		return nil, err
+	}
+	if !json.Valid(b.Bytes()) {
+		return nil, fmt.Errorf("template did not render valid JSON: %s", b.String())
+	}
+	return b.Bytes(), nil
+}
+
+// Sign returns the signature sent in the X-Playout-Signature header: the hex encoded
+// HMAC-SHA256 of body with secret, prefixed by "sha256=".
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type permanentError struct{ error }
+
+func (h *Hook) post(ctx context.Context, client *http.Client, t events.Type, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Playout-Event", string(t))
+	if h.Secret != "" {
+		req.Header.Set("X-Playout-Signature", Sign(h.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	default:
+		return permanentError{fmt.Errorf("receiver answered %s", resp.Status)}
+	}
+}
+
+// Deliver sends e to the hook, retrying with exponential backoff until the retries
+// are used up, the receiver rejects the payload or ctx is done.
+func (h *Hook) Deliver(ctx context.Context, client *http.Client, e events.Event) error {
+	body, err := h.payload(e)
+	if err != nil {
+		metrics.WebhookDeliveries.Add("failed", 1)
+		return err
+	}
+	backoff := initialBackoff
+	for attempt := 0; ; attempt++ {
+		err = h.post(ctx, client, e.Type, body)
+		if err == nil {
+			metrics.WebhookDeliveries.Add("ok", 1)
+			return nil
+		}
+		if _, ok := err.(permanentError); ok || attempt >= h.retries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			metrics.WebhookDeliveries.Add("failed", 1)
+			return err
+		}
+		metrics.WebhookDeliveries.Add("retried", 1)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	metrics.WebhookDeliveries.Add("failed", 1)
+	return err
+}
+
+// work delivers the events of queue to the hook one after another until queue is closed.
+// Once ctx is done the remaining events are dropped.
+func (h *Hook) work(ctx context.Context, client *http.Client, queue <-chan events.Event) {
+	for e := range queue {
+		if ctx.Err() != nil {
+			continue
+		}
+		if err := h.Deliver(ctx, client, e); err != nil {
+			log.Printf("Webhook %s: failed to deliver %s: %v", h.Redacted().URL, e.Type, err)
+		}
+	}
+}
+
+// Run delivers the events of bus to the hooks returned by hooks until ctx is done.
+// hooks is called for every event, so a reloaded configuration applies immediately.
+// Every hook gets its own worker, which delivers the events in order. If a hook falls
+// more than queueSize events behind, further events for it are dropped.
+func Run(ctx context.Context, bus *events.Bus, hooks func() []*Hook) chan struct{} {
+	sub := bus.Subscribe("webhooks", 64)
+	client := &http.Client{}
+	done := make(chan struct{})
+	go func() {
+		var workers sync.WaitGroup
+		queues := map[*Hook]chan events.Event{}
+		defer close(done)
+		defer workers.Wait()
+		defer func() {
+			for _, queue := range queues {
+				close(queue)
+			}
+		}()
+		defer sub.Close()
+		for {
+			select {
+			case e := <-sub.Events():
+				current := hooks()
+				active := make(map[*Hook]bool, len(current))
+				for _, h := range current {
+					active[h] = true
+					if !h.Wants(e.Type) {
+						continue
+					}
+					queue, ok := queues[h]
+					if !ok {
+						queue = make(chan events.Event, queueSize)
+						queues[h] = queue
+						workers.Add(1)
+						go func(h *Hook) {
+							defer workers.Done()
+							h.work(ctx, client, queue)
+						}(h)
+					}
+					select {
+					case queue <- e:
+					default:
+						metrics.WebhookDeliveries.Add("dropped", 1)
+						log.Printf("Webhook %s: dropped %s, too many pending deliveries", h.Redacted().URL, e.Type)
+					}
+				}
+				// workers of hooks removed by a reload finish their queue and stop
+				for h, queue := range queues {
+					if !active[h] {
+						close(queue)
+						delete(queues, h)
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,232 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Garionion/playout-controller/events"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	initialBackoff = time.Millisecond
+}
+
+// receiver answers the requests with statuses, the last one is repeated.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := r.statuses[0]
+	if len(r.statuses) > 1 {
+		r.statuses = r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func newHook(t *testing.T, c Config) *Hook {
+	hooks, err := Compile([]Config{c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hooks[0]
+}
+
+func retries(n int) *int {
+	return &n
+}
+
+var failed = events.Event{Type: events.JobFailed, Job: &fahrplan.PlayoutJob{GUID: "a", ID: 1}, Error: "unavailable"}
+
+func TestSignature(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusNoContent}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	h := newHook(t, Config{URL: srv.URL, Secret: "s3cret"})
+	if err := h.Deliver(context.Background(), srv.Client(), failed); err != nil {
+		t.Fatal(err)
+	}
+	if r.count() != 1 {
+		t.Fatalf("got %d requests, want 1", r.count())
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(r.bodies[0])
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	req := r.requests[0]
+	if got := req.Header.Get("X-Playout-Signature"); got != want || Sign("s3cret", r.bodies[0]) != want {
+		t.Errorf("got signature %q, want %q", got, want)
+	}
+	if got := req.Header.Get("X-Playout-Event"); got != string(events.JobFailed) {
+		t.Errorf("got event header %q", got)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("got content type %q", got)
+	}
+}
+
+func TestNoSignatureWithoutSecret(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusOK}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	if err := newHook(t, Config{URL: srv.URL}).Deliver(context.Background(), srv.Client(), failed); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.requests[0].Header.Get("X-Playout-Signature"); got != "" {
+		t.Errorf("got signature %q without a secret", got)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		requests int
+		ok       bool
+	}{
+		{"success", []int{http.StatusOK}, 3, 1, true},
+		{"server error", []int{http.StatusBadGateway, http.StatusInternalServerError, http.StatusOK}, 3, 3, true},
+		{"too many requests", []int{http.StatusTooManyRequests, http.StatusAccepted}, 3, 2, true},
+		{"retries used up", []int{http.StatusServiceUnavailable}, 2, 3, false},
+		{"no retries", []int{http.StatusServiceUnavailable}, 0, 1, false},
+		{"bad request", []int{http.StatusBadRequest, http.StatusOK}, 3, 1, false},
+		{"not found", []int{http.StatusNotFound, http.StatusOK}, 3, 1, false},
+		{"client error after server error", []int{http.StatusBadGateway, http.StatusUnauthorized, http.StatusOK}, 3, 2, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &receiver{statuses: test.statuses}
+			srv := httptest.NewServer(r)
+			defer srv.Close()
+			h := newHook(t, Config{URL: srv.URL, Retries: retries(test.retries)})
+			err := h.Deliver(context.Background(), srv.Client(), failed)
+			if (err == nil) != test.ok {
+				t.Errorf("got error %v, want success %v", err, test.ok)
+			}
+			if r.count() != test.requests {
+				t.Errorf("got %d requests, want %d", r.count(), test.requests)
+			}
+		})
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusOK}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	h := newHook(t, Config{URL: srv.URL, Template: `{"text": {{json .Error}}, "guid": {{json .Job.GUID}}}`})
+	if err := h.Deliver(context.Background(), srv.Client(), failed); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(r.bodies[0]), `{"text": "unavailable", "guid": "a"}`; got != want {
+		t.Errorf("got payload %s, want %s", got, want)
+	}
+
+	h = newHook(t, Config{URL: srv.URL, Template: `{"text": {{.Error}}}`})
+	if err := h.Deliver(context.Background(), srv.Client(), failed); err == nil {
+		t.Error("delivered a template which rendered invalid JSON")
+	}
+	if r.count() != 1 {
+		t.Errorf("invalid payload was sent")
+	}
+
+	if _, err := Compile([]Config{{URL: srv.URL, Template: `{{.Error`}}); err == nil {
+		t.Error("compiled a broken template")
+	}
+}
+
+func TestWants(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		want   map[events.Type]bool
+	}{
+		{"default", nil, map[events.Type]bool{
+			events.JobFailed:       true,
+			events.JobScheduled:    true,
+			events.VersionChanged:  true,
+			events.RoomUnresolved:  true,
+			events.FahrplanUpdated: false,
+			events.UpcomingChanged: false,
+			events.JobStateChanged: false,
+		}},
+		{"filtered", []string{"jobFailed", "jobStateChanged"}, map[events.Type]bool{
+			events.JobFailed:       true,
+			events.JobStateChanged: true,
+			events.JobScheduled:    false,
+			events.VersionChanged:  false,
+			events.UpcomingChanged: false,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHook(t, Config{URL: "http://localhost/hook", Events: test.events})
+			for typ, want := range test.want {
+				if got := h.Wants(typ); got != want {
+					t.Errorf("%s: got %v, want %v", typ, got, want)
+				}
+			}
+		})
+	}
+	if _, err := Compile([]Config{{URL: "http://localhost/hook", Events: []string{"jobExploded"}}}); err == nil {
+		t.Error("accepted an unknown event type")
+	}
+}
+
+func TestRunDeliversInOrder(t *testing.T) {
+	const n = 20
+	r := &receiver{statuses: []int{http.StatusOK}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	h := newHook(t, Config{URL: srv.URL, Events: []string{"versionChanged"}})
+
+	bus := events.NewBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := Run(ctx, bus, func() []*Hook { return []*Hook{h} })
+	for i := 0; i < n; i++ {
+		bus.Publish(events.Event{Type: events.VersionChanged, Version: string(rune('a' + i))})
+		bus.Publish(events.Event{Type: events.JobFailed})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for r.count() < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if r.count() != n {
+		t.Fatalf("got %d deliveries, want %d", r.count(), n)
+	}
+	for i, body := range r.bodies {
+		e := events.Event{}
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Fatal(err)
+		}
+		if want := string(rune('a' + i)); e.Version != want {
+			t.Errorf("delivery %d: got version %q, want %q", i, e.Version, want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/Garionion/playout-controller/events"
+	"github.com/Garionion/playout-controller/fahrplan"
+	"github.com/gofiber/fiber/v2"
+	"net/http"
+	"time"
+)
+
+// sampleEvent returns an event of type t with made up content for testing receivers.
+func sampleEvent(t events.Type) events.Event {
+	job := fahrplan.PlayoutJob{
+		GUID:     "00000000-0000-0000-0000-000000000000",
+		ID:       1,
+		Start:    time.Now().Add(time.Hour),
+		Duration: 30 * time.Minute,
+		Room:     "Test Room",
+		Title:    "Webhook test",
+	}
+	e := events.Event{Type: t, Job: &job, Room: job.Room}
+	switch t {
+	case events.JobFailed:
+		e.Error = "test failure"
+	case events.VersionChanged:
+		e.Version = "test"
+	}
+	return e
+}
+
+func registerWebhookRoutes(api fiber.Router, config *configHolder) {
+	// /webhooks/test sends a sample event of the given type to every webhook
+	// interested in it and reports the outcome per webhook.
+	api.Post("/webhooks/test", func(c *fiber.Ctx) error {
+		t, err := events.ParseType(c.Query("type", string(events.JobFailed)))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		e := sampleEvent(t)
+		e.Time = time.Now()
+		type result struct {
+			URL   string `json:"url"`
+			Error string `json:"error,omitempty"`
+		}
+		results := []result{}
+		for _, h := range config.Get().webhooks {
+			if !h.Wants(t) {
+				continue
+			}
+			r := result{URL: h.Redacted().URL}
+			if err := h.Deliver(c.Context(), http.DefaultClient, e); err != nil {
+				r.Error = err.Error()
+			}
+			results = append(results, r)
+		}
+		return c.JSON(results)
+	})
+}